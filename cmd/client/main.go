@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/leelynne/omnilink/omni/home"
+	"github.com/leelynne/omnilink/omni/proto"
 )
 
 func main() {
@@ -17,7 +19,7 @@ func main() {
 	flag.StringVar(&key, "key", "", "client key")
 	flag.Parse()
 
-	h, err := home.New(logger, endpoint, key)
+	h, err := home.New(context.Background(), proto.StdLogger{Logger: logger}, endpoint, key)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/home"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "omni"
+
+// humiditySensorType is the auxiliary sensor type of humidity sensors, whose readings are humidities.
+const humiditySensorType = 84
+
+var (
+	upDesc = prometheus.NewDesc(namespace+"_up",
+		"Whether the last poll of the controller succeeded.", nil, nil)
+	batteryDesc = prometheus.NewDesc(namespace+"_battery_reading",
+		"Battery reading of the controller, from 0 to 255.", nil, nil)
+	troubleDesc = prometheus.NewDesc(namespace+"_system_trouble",
+		"Whether a system trouble is active.", []string{"trouble"}, nil)
+
+	zoneConditionDesc = prometheus.NewDesc(namespace+"_zone_condition",
+		"Current condition of a zone: 0 secure, 1 not ready, 2 trouble, 3 tamper.", []string{"zone", "name"}, nil)
+	zoneLatchedDesc = prometheus.NewDesc(namespace+"_zone_latched_alarm",
+		"Latched alarm status of a zone: 0 secure, 1 tripped, 2 reset but not secure.", []string{"zone", "name"}, nil)
+	zoneArmingDesc = prometheus.NewDesc(namespace+"_zone_arming",
+		"Arming status of a zone: 0 disarmed, 1 armed, 2 bypassed by user, 3 bypassed by system.", []string{"zone", "name"}, nil)
+	zoneLoopDesc = prometheus.NewDesc(namespace+"_zone_loop_reading",
+		"Analog loop reading of a zone.", []string{"zone", "name"}, nil)
+
+	areaModeDesc = prometheus.NewDesc(namespace+"_area_mode",
+		"Security mode of an area: 0 off, 1 day, 2 night, 3 away, 4 vacation, 5 day instant, 6 night delayed.", []string{"area", "name"}, nil)
+	areaAlarmsDesc = prometheus.NewDesc(namespace+"_area_alarms",
+		"Active alarms of an area, bits 0-7 are burglary, fire, gas, auxiliary, freeze, water, duress and temperature.", []string{"area", "name"}, nil)
+
+	unitLevelDesc = prometheus.NewDesc(namespace+"_unit_level_percent",
+		"Level of a unit, units without levels report 100 when on.", []string{"unit", "name"}, nil)
+
+	thermostatTempDesc = prometheus.NewDesc(namespace+"_thermostat_temperature_celsius",
+		"Current temperature at a thermostat.", []string{"thermostat", "name"}, nil)
+	thermostatHeatDesc = prometheus.NewDesc(namespace+"_thermostat_heat_setpoint_celsius",
+		"Heat setpoint of a thermostat.", []string{"thermostat", "name"}, nil)
+	thermostatCoolDesc = prometheus.NewDesc(namespace+"_thermostat_cool_setpoint_celsius",
+		"Cool setpoint of a thermostat.", []string{"thermostat", "name"}, nil)
+	thermostatModeDesc = prometheus.NewDesc(namespace+"_thermostat_mode",
+		"System mode of a thermostat: 0 off, 1 heat, 2 cool, 3 auto, 4 emergency heat.", []string{"thermostat", "name"}, nil)
+	thermostatHumidityDesc = prometheus.NewDesc(namespace+"_thermostat_humidity_percent",
+		"Relative humidity at a thermostat, requires firmware 3.0.", []string{"thermostat", "name"}, nil)
+	thermostatHumidifyDesc = prometheus.NewDesc(namespace+"_thermostat_humidify_setpoint_percent",
+		"Humidify setpoint of a thermostat, requires firmware 3.0.", []string{"thermostat", "name"}, nil)
+	thermostatDehumidifyDesc = prometheus.NewDesc(namespace+"_thermostat_dehumidify_setpoint_percent",
+		"Dehumidify setpoint of a thermostat, requires firmware 3.0.", []string{"thermostat", "name"}, nil)
+	thermostatOutdoorDesc = prometheus.NewDesc(namespace+"_thermostat_outdoor_temperature_celsius",
+		"Outdoor temperature reported to a thermostat, requires firmware 3.0.", []string{"thermostat", "name"}, nil)
+
+	auxTempDesc = prometheus.NewDesc(namespace+"_aux_temperature_celsius",
+		"Temperature at an auxiliary temperature sensor.", []string{"sensor", "name"}, nil)
+	auxHumidityDesc = prometheus.NewDesc(namespace+"_aux_humidity_percent",
+		"Relative humidity at an auxiliary humidity sensor.", []string{"sensor", "name"}, nil)
+	auxOutputDesc = prometheus.NewDesc(namespace+"_aux_output",
+		"Output status of an auxiliary sensor.", []string{"sensor", "name"}, nil)
+)
+
+// collector polls the controller each time it is scraped. The names of objects are loaded once, and
+// objects without names are not exported.
+type collector struct {
+	client  *omni.Client
+	logger  *log.Logger
+	timeout time.Duration
+
+	mu sync.Mutex // Held while polling, so concurrent scrapes do not multiply the requests
+
+	zones       map[int]string
+	units       map[int]string
+	areas       map[int]string
+	thermostats map[int]string
+	auxSensors  map[int]string
+	auxTypes    map[int]uint8
+	extended    bool // The controller supports extended thermostat status
+
+	requestDuration *prometheus.HistogramVec
+	requestErrors   *prometheus.CounterVec
+}
+
+func newCollector(client *omni.Client, logger *log.Logger, timeout time.Duration) *collector {
+	return &collector{
+		client:   client,
+		logger:   logger,
+		timeout:  timeout,
+		extended: true,
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests to the controller.",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"request"}),
+		requestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_errors_total",
+			Help:      "Requests to the controller which failed.",
+		}, []string{"request"}),
+	}
+}
+
+// load reads the names of the objects to export.
+func (c *collector) load(ctx context.Context) error {
+	c.zones = map[int]string{}
+	err := c.observe("zone_properties", func() error {
+		props, err := c.client.Zones(ctx)
+		for _, p := range props {
+			c.zones[omni.ObjectNumber(p.NumberMSB, p.NumberLSB)] = omni.ObjectName(p.Name[:])
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.units = map[int]string{}
+	err = c.observe("unit_properties", func() error {
+		props, err := c.client.Units(ctx)
+		for _, p := range props {
+			c.units[omni.ObjectNumber(p.NumberMSB, p.NumberLSB)] = omni.ObjectName(p.Name[:])
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.areas = map[int]string{}
+	err = c.observe("area_properties", func() error {
+		props, err := c.client.Areas(ctx)
+		for _, p := range props {
+			c.areas[omni.ObjectNumber(p.NumberMSB, p.NumberLSB)] = omni.ObjectName(p.Name[:])
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.thermostats = map[int]string{}
+	err = c.observe("thermostat_properties", func() error {
+		props, err := c.client.Thermostats(ctx)
+		for _, p := range props {
+			c.thermostats[omni.ObjectNumber(p.NumberMSB, p.NumberLSB)] = omni.ObjectName(p.Name[:])
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.auxSensors = map[int]string{}
+	c.auxTypes = map[int]uint8{}
+	return c.observe("aux_properties", func() error {
+		props, err := c.client.AuxilarySensors(ctx)
+		for _, p := range props {
+			n := omni.ObjectNumber(p.NumberMSB, p.NumberLSB)
+			c.auxSensors[n] = omni.ObjectName(p.Name[:])
+			c.auxTypes[n] = p.Type
+		}
+		return err
+	})
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		upDesc, batteryDesc, troubleDesc,
+		zoneConditionDesc, zoneLatchedDesc, zoneArmingDesc, zoneLoopDesc,
+		areaModeDesc, areaAlarmsDesc, unitLevelDesc,
+		thermostatTempDesc, thermostatHeatDesc, thermostatCoolDesc, thermostatModeDesc,
+		thermostatHumidityDesc, thermostatHumidifyDesc, thermostatDehumidifyDesc, thermostatOutdoorDesc,
+		auxTempDesc, auxHumidityDesc, auxOutputDesc,
+	} {
+		ch <- d
+	}
+	c.requestDuration.Describe(ch)
+	c.requestErrors.Describe(ch)
+}
+
+// Collect polls the controller. Metrics are sent for whatever was read before a request failed, and
+// omni_up reports whether every request succeeded.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	err := c.collect(ctx, ch)
+	up := 1.0
+	if err != nil {
+		c.logger.Printf("Failed to poll controller: %s", err)
+		up = 0
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up)
+	c.requestDuration.Collect(ch)
+	c.requestErrors.Collect(ch)
+}
+
+func (c *collector) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+
+	err := c.observe("system_status", func() error {
+		st, err := c.client.GetSystemStatus(ctx)
+		if err == nil {
+			gauge(batteryDesc, float64(st.Battery))
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = c.observe("system_troubles", func() error {
+		st, err := c.client.GetSystemTroubles(ctx)
+		for _, t := range st.Troubles {
+			gauge(troubleDesc, 1, t.String())
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if r, ok := rangeOf(c.zones); ok {
+		err = c.observe("zone_status", func() error {
+			st, err := c.client.ZoneStatus(ctx, r)
+			for _, s := range st {
+				labels, ok := labelsFor(c.zones, s.NumberMSB, s.NumberLSB)
+				if !ok {
+					continue
+				}
+				gauge(zoneConditionDesc, float64(s.Status&0x03), labels...)
+				gauge(zoneLatchedDesc, float64(s.Status>>2&0x03), labels...)
+				gauge(zoneArmingDesc, float64(s.Status>>4&0x03), labels...)
+				gauge(zoneLoopDesc, float64(s.LoopReading), labels...)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r, ok := rangeOf(c.units); ok {
+		err = c.observe("unit_status", func() error {
+			st, err := c.client.UnitStatus(ctx, r)
+			for _, s := range st {
+				labels, ok := labelsFor(c.units, s.NumberMSB, s.NumberLSB)
+				if !ok {
+					continue
+				}
+				gauge(unitLevelDesc, float64(home.Unit{State: s.State}.Level()), labels...)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r, ok := rangeOf(c.areas); ok {
+		err = c.observe("area_status", func() error {
+			st, err := c.client.AreaStatus(ctx, r)
+			for _, s := range st {
+				labels, ok := labelsFor(c.areas, s.NumberMSB, s.NumberLSB)
+				if !ok {
+					continue
+				}
+				gauge(areaModeDesc, float64(s.Mode), labels...)
+				gauge(areaAlarmsDesc, float64(s.Alarms), labels...)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r, ok := rangeOf(c.thermostats); ok {
+		err = c.collectThermostats(ctx, r, gauge)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r, ok := rangeOf(c.auxSensors); ok {
+		err = c.observe("aux_status", func() error {
+			st, err := c.client.AuxilarySensorStatus(ctx, r)
+			for _, s := range st {
+				labels, ok := labelsFor(c.auxSensors, s.NumberMSB, s.NumberLSB)
+				if !ok {
+					continue
+				}
+				if c.auxTypes[omni.ObjectNumber(s.NumberMSB, s.NumberLSB)] == humiditySensorType {
+					gauge(auxHumidityDesc, float64(omni.Humidity(s.Temperature).Percent()), labels...)
+				} else {
+					gauge(auxTempDesc, s.Temperature.Celsius(), labels...)
+				}
+				gauge(auxOutputDesc, float64(s.OutputStatus), labels...)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectThermostats reads the extended thermostat status. Once the controller has refused it, only the
+// basic status is read.
+func (c *collector) collectThermostats(ctx context.Context, r omni.Range, gauge func(*prometheus.Desc, float64, ...string)) error {
+	basic := func(s omni.ThermostatStatus, labels []string) {
+		gauge(thermostatTempDesc, s.CurrentTemp.Celsius(), labels...)
+		gauge(thermostatHeatDesc, s.HeatSetPoint.Celsius(), labels...)
+		gauge(thermostatCoolDesc, s.CoolSetPoint.Celsius(), labels...)
+		gauge(thermostatModeDesc, float64(s.SystemMode), labels...)
+	}
+
+	if c.extended {
+		err := c.observe("thermostat_extended_status", func() error {
+			st, err := c.client.ExtendedThermostatStatus(ctx, r)
+			for _, s := range st {
+				labels, ok := labelsFor(c.thermostats, s.NumberMSB, s.NumberLSB)
+				if !ok {
+					continue
+				}
+				basic(s.ThermostatStatus, labels)
+				gauge(thermostatHumidityDesc, float64(s.Humidity.Percent()), labels...)
+				gauge(thermostatHumidifyDesc, float64(s.HumidifySetPoint.Percent()), labels...)
+				gauge(thermostatDehumidifyDesc, float64(s.DehumidifySetPoint.Percent()), labels...)
+				gauge(thermostatOutdoorDesc, s.OutdoorTemperature.Celsius(), labels...)
+			}
+			return err
+		})
+		if !errors.Is(err, omni.ErrNegativeAck) {
+			return err
+		}
+		c.logger.Printf("Extended thermostat status unavailable, using basic status: %s", err)
+		c.extended = false
+	}
+
+	return c.observe("thermostat_status", func() error {
+		st, err := c.client.ThermostatStatus(ctx, r)
+		for _, s := range st {
+			labels, ok := labelsFor(c.thermostats, s.NumberMSB, s.NumberLSB)
+			if !ok {
+				continue
+			}
+			basic(s, labels)
+		}
+		return err
+	})
+}
+
+// observe records the duration of a request and whether it failed.
+func (c *collector) observe(request string, f func() error) error {
+	start := time.Now()
+	err := f()
+	c.requestDuration.WithLabelValues(request).Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.requestErrors.WithLabelValues(request).Inc()
+	}
+	return err
+}
+
+// rangeOf returns the range covering the numbers of the named objects.
+func rangeOf(names map[int]string) (omni.Range, bool) {
+	r := omni.Range{}
+	for n := range names {
+		if r.Start == 0 || n < r.Start {
+			r.Start = n
+		}
+		if n > r.End {
+			r.End = n
+		}
+	}
+	return r, len(names) > 0
+}
+
+// labelsFor returns the number and name labels of a named object.
+func labelsFor(names map[int]string, msb, lsb uint8) ([]string, bool) {
+	n := omni.ObjectNumber(msb, lsb)
+	name, ok := names[n]
+	return []string{strconv.Itoa(n), name}, ok
+}
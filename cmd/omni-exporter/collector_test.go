@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/omnisim"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testInstallation returns the demo installation with one unit and two auxiliary sensors.
+func testInstallation() *omnisim.Installation {
+	inst := omnisim.Demo()
+	inst.Units = map[int]*omnisim.Unit{5: {Name: "PORCH", State: 150}}
+	inst.AuxilarySensors = map[int]*omnisim.AuxilarySensor{
+		1: {Name: "GARAGE", Temperature: 122},
+		2: {Name: "BASEMENT", Type: humiditySensorType, Temperature: 100, OutputStatus: 1},
+	}
+	th := inst.Thermostats[1]
+	th.Humidity = 100
+	th.HumidifySetPoint = 44
+	th.DehumidifySetPoint = 156
+	return inst
+}
+
+// newTestCollector starts a simulator of inst and returns a collector which has loaded its objects. The
+// client and simulator are closed by the returned function.
+func newTestCollector(t *testing.T, inst *omnisim.Installation) (*collector, func()) {
+	t.Helper()
+	sim, err := omnisim.ListenDemo(inst)
+	if err != nil {
+		t.Fatalf("Failed to start simulator: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := omni.NewClient(ctx, sim.Addr(), omnisim.DemoKey)
+	if err != nil {
+		sim.Close()
+		t.Fatalf("Failed to connect to simulator: %s", err)
+	}
+	col := newCollector(c, log.New(ioutil.Discard, "", 0), 5*time.Second)
+	err = col.load(ctx)
+	if err != nil {
+		c.Close()
+		sim.Close()
+		t.Fatalf("Failed to load objects: %s", err)
+	}
+	return col, func() {
+		c.Close()
+		sim.Close()
+	}
+}
+
+// gather scrapes col and returns the value of each gauge and counter, and the sample count of each
+// histogram with a _count suffix, keyed by the metric name and labels.
+func gather(t *testing.T, col *collector) map[string]float64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(col)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %s", err)
+	}
+	metrics := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			sort.Strings(labels)
+			key := f.GetName()
+			if len(labels) > 0 {
+				key += "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case m.Gauge != nil:
+				metrics[key] = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				metrics[key] = m.GetCounter().GetValue()
+			case m.Histogram != nil:
+				metrics[f.GetName()+"_count"+key[len(f.GetName()):]] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return metrics
+}
+
+func TestCollect(t *testing.T) {
+	col, done := newTestCollector(t, testInstallation())
+	defer done()
+
+	metrics := gather(t, col)
+	for key, want := range map[string]float64{
+		`omni_up`:                                                                       1,
+		`omni_battery_reading`:                                                          200,
+		`omni_system_trouble{trouble="ACPower"}`:                                        1,
+		`omni_zone_condition{name="BACK DOOR",zone="2"}`:                                1,
+		`omni_zone_condition{name="FRONT DOOR",zone="1"}`:                               0,
+		`omni_zone_loop_reading{name="FRONT DOOR",zone="1"}`:                            120,
+		`omni_unit_level_percent{name="PORCH",unit="5"}`:                                50,
+		`omni_area_mode{area="1",name="HOUSE"}`:                                         0,
+		`omni_thermostat_temperature_celsius{name="DOWNSTAIRS",thermostat="1"}`:         21,
+		`omni_thermostat_heat_setpoint_celsius{name="DOWNSTAIRS",thermostat="1"}`:       20,
+		`omni_thermostat_cool_setpoint_celsius{name="DOWNSTAIRS",thermostat="1"}`:       25,
+		`omni_thermostat_mode{name="DOWNSTAIRS",thermostat="1"}`:                        float64(omni.ThermostatAuto),
+		`omni_thermostat_humidity_percent{name="DOWNSTAIRS",thermostat="1"}`:            50,
+		`omni_thermostat_humidify_setpoint_percent{name="DOWNSTAIRS",thermostat="1"}`:   0,
+		`omni_thermostat_dehumidify_setpoint_percent{name="DOWNSTAIRS",thermostat="1"}`: 100,
+		`omni_thermostat_outdoor_temperature_celsius{name="DOWNSTAIRS",thermostat="1"}`: 5,
+		`omni_aux_temperature_celsius{name="GARAGE",sensor="1"}`:                        21,
+		`omni_aux_humidity_percent{name="BASEMENT",sensor="2"}`:                         50,
+		`omni_aux_output{name="BASEMENT",sensor="2"}`:                                   1,
+		`omni_request_duration_seconds_count{request="thermostat_extended_status"}`:     1,
+	} {
+		got, ok := metrics[key]
+		if !ok {
+			t.Errorf("Missing metric %s", key)
+		} else if got != want {
+			t.Errorf("Metric %s is %v, want %v", key, got, want)
+		}
+	}
+	for key := range metrics {
+		if strings.HasPrefix(key, "omni_request_errors_total") {
+			t.Errorf("Unexpected request error %s", key)
+		}
+		if strings.HasPrefix(key, "omni_aux_humidity_percent") && strings.Contains(key, "GARAGE") {
+			t.Errorf("Temperature sensor reported as %s", key)
+		}
+	}
+}
+
+// TestCollectBasicThermostat scrapes a controller without extended status, which naks the request. The
+// collector must fall back to the basic thermostat status and stop asking for the extended status.
+func TestCollectBasicThermostat(t *testing.T) {
+	inst := testInstallation()
+	inst.Info.MajorVersion = 2
+	col, done := newTestCollector(t, inst)
+	defer done()
+
+	gather(t, col)
+	metrics := gather(t, col)
+	for key, want := range map[string]float64{
+		`omni_up`: 1,
+		`omni_thermostat_temperature_celsius{name="DOWNSTAIRS",thermostat="1"}`:     21,
+		`omni_thermostat_heat_setpoint_celsius{name="DOWNSTAIRS",thermostat="1"}`:   20,
+		`omni_request_errors_total{request="thermostat_extended_status"}`:           1,
+		`omni_request_duration_seconds_count{request="thermostat_extended_status"}`: 1,
+		`omni_request_duration_seconds_count{request="thermostat_status"}`:          2,
+	} {
+		got, ok := metrics[key]
+		if !ok {
+			t.Errorf("Missing metric %s", key)
+		} else if got != want {
+			t.Errorf("Metric %s is %v, want %v", key, got, want)
+		}
+	}
+	for key := range metrics {
+		if strings.HasPrefix(key, "omni_thermostat_humidity_percent") {
+			t.Errorf("Basic status reported %s", key)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Command omni-exporter serves the state of an Omni controller as Prometheus metrics. It keeps a session
+// open with the controller and polls it each time /metrics is scraped.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	logger := log.New(os.Stderr, "omni-exporter: ", log.LstdFlags)
+	var endpoint, key, listen string
+	var timeout time.Duration
+	flag.StringVar(&endpoint, "endpoint", "", "controller address to connect to, port 4369 is used if none is given")
+	flag.StringVar(&key, "key", os.Getenv("OMNI_KEY"), "controller key, defaults to $OMNI_KEY")
+	flag.StringVar(&listen, "listen", ":9369", "address to serve /metrics on")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "time allowed to poll the controller on each scrape")
+	flag.Parse()
+	if endpoint == "" || key == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	connErrors := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connection_errors_total",
+		Help:      "Connections to the controller which failed or were lost.",
+	})
+
+	ctx := context.Background()
+	c, err := omni.NewClient(ctx, omni.ControllerAddr(endpoint), key,
+		omni.WithLogger(proto.StdLogger{Logger: logger}),
+		omni.WithReconnect(time.Second, time.Minute),
+		omni.WithStateFunc(func(state omni.ConnState, err error) {
+			if state == omni.Lost {
+				connErrors.Inc()
+			}
+		}),
+	)
+	if err != nil {
+		logger.Fatalf("Failed to connect to controller: %s", err)
+	}
+
+	col := newCollector(c, logger, timeout)
+	loadCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	err = col.load(loadCtx)
+	cancel()
+	if err != nil {
+		logger.Fatalf("Failed to load objects: %s", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(col, connErrors)
+
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorLog: logger}))
+	logger.Printf("Serving metrics on %s", listen)
+	logger.Fatal(http.ListenAndServe(listen, nil))
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/home"
+	"github.com/pkg/errors"
+)
+
+// Topics are laid out under the prefix as <prefix>/<object type>/<number>/<field>, with commands
+// published to the same topic plus /set. <prefix>/status is "online" while the bridge is connected to
+// the controller and "offline" otherwise.
+
+const (
+	// commandTimeout bounds each command sent to the controller.
+	commandTimeout = 10 * time.Second
+	// publishQueueSize is the number of messages which may wait to be published, enough for the state
+	// and discovery configs of a fully configured controller.
+	publishQueueSize = 4096
+)
+
+// bridge publishes the state of a Home to MQTT and applies the commands received.
+type bridge struct {
+	home    *home.Home
+	client  *omni.Client
+	logger  *log.Logger
+	prefix  string
+	format  omni.TempFormat
+	discov  discovery
+	mu      sync.Mutex
+	broker  mqtt.Client // Nil while disconnected from the broker
+	online  bool        // Connected to the controller
+	command chan message
+	queue   chan message // Messages waiting to be published
+}
+
+// message is a message received from the broker.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+func newBridge(h *home.Home, c *omni.Client, logger *log.Logger, prefix string, discov discovery) *bridge {
+	b := &bridge{
+		home:    h,
+		client:  c,
+		logger:  logger,
+		prefix:  prefix,
+		format:  h.Formats().TempFormat,
+		discov:  discov,
+		online:  true,
+		command: make(chan message, 64),
+		queue:   make(chan message, publishQueueSize),
+	}
+	h.OnZoneChange(func(old, new home.Zone) { b.publishZone(new) })
+	h.OnUnitChange(func(old, new home.Unit) { b.publishUnit(new) })
+	h.OnAreaChange(func(old, new home.Area) { b.publishArea(new) })
+	h.OnThermostatChange(func(old, new home.Thermostat) { b.publishThermostat(new) })
+	return b
+}
+
+// run applies commands and publishes queued messages until ctx is done.
+func (b *bridge) run(ctx context.Context) {
+	go b.send(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-b.command:
+			err := b.apply(ctx, m.topic, string(m.payload))
+			if err != nil {
+				b.logger.Printf("Failed to apply %s %q: %s", m.topic, m.payload, err)
+			}
+		}
+	}
+}
+
+// send publishes queued messages until ctx is done. Messages are published from here rather than from
+// the Home's change functions, so a slow broker does not hold up the Home.
+func (b *bridge) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-b.queue:
+			b.mu.Lock()
+			m := b.broker
+			b.mu.Unlock()
+			if m == nil {
+				continue
+			}
+			err := wait(m.Publish(msg.topic, 0, true, msg.payload))
+			if err != nil {
+				b.logger.Printf("Failed to publish %s: %s", msg.topic, err)
+			}
+		}
+	}
+}
+
+// attach starts publishing to the broker once connected. The subscriptions are made, and the discovery
+// configs and current state are published, since the broker may have lost them.
+func (b *bridge) attach(m mqtt.Client) error {
+	b.mu.Lock()
+	b.broker = m
+	b.mu.Unlock()
+
+	filters := map[string]byte{b.prefix + "/+/+/set": 0, b.prefix + "/+/+/+/set": 0}
+	if b.discov.prefix != "" {
+		filters[b.discov.prefix+"/status"] = 0
+	}
+	err := wait(m.SubscribeMultiple(filters, func(_ mqtt.Client, msg mqtt.Message) {
+		b.handle(msg.Topic(), msg.Payload())
+	}))
+	if err != nil {
+		return errors.Wrap(err, "Failed to subscribe")
+	}
+	b.publishAll()
+	return nil
+}
+
+// detach stops publishing to the broker while disconnected.
+func (b *bridge) detach(m mqtt.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.broker == m {
+		b.broker = nil
+	}
+}
+
+// handle queues a message from the broker. Home Assistant announces it has started on its status
+// topic, and the discovery configs are published again in case it has not retained them.
+func (b *bridge) handle(topic string, payload []byte) {
+	if b.discov.prefix != "" && topic == b.discov.prefix+"/status" {
+		if string(payload) == "online" {
+			go b.publishAll()
+		}
+		return
+	}
+	select {
+	case b.command <- message{topic: topic, payload: payload}:
+	default:
+		b.logger.Printf("Dropped command %s, too many commands waiting", topic)
+	}
+}
+
+// setOnline publishes whether the bridge is connected to the controller.
+func (b *bridge) setOnline(online bool) {
+	b.mu.Lock()
+	b.online = online
+	b.mu.Unlock()
+	b.publishStatus()
+}
+
+func (b *bridge) publishStatus() {
+	b.mu.Lock()
+	status := "offline"
+	if b.online {
+		status = "online"
+	}
+	b.mu.Unlock()
+	b.publish(b.prefix+"/status", status)
+}
+
+// publishAll publishes the discovery configs and the state of every object.
+func (b *bridge) publishAll() {
+	b.publishStatus()
+	if b.discov.prefix != "" {
+		for topic, config := range b.discov.configs(b.home, b.prefix, b.format) {
+			payload, err := json.Marshal(config)
+			if err != nil {
+				b.logger.Printf("Failed to encode discovery config %s: %s", topic, err)
+				continue
+			}
+			b.publish(topic, string(payload))
+		}
+	}
+	for _, z := range b.home.Zones() {
+		b.publishZone(z)
+	}
+	for _, u := range b.home.Units() {
+		b.publishUnit(u)
+	}
+	for _, a := range b.home.Areas() {
+		b.publishArea(a)
+	}
+	for _, t := range b.home.Thermostats() {
+		b.publishThermostat(t)
+	}
+}
+
+func (b *bridge) publishZone(z home.Zone) {
+	b.publishField("zone", z.Number, "state", onOff(!z.Secure()))
+	b.publishField("zone", z.Number, "trouble", onOff(z.Trouble()))
+	b.publishField("zone", z.Number, "bypassed", onOff(z.Bypassed()))
+}
+
+func (b *bridge) publishUnit(u home.Unit) {
+	b.publishField("unit", u.Number, "state", onOff(u.On()))
+	b.publishField("unit", u.Number, "brightness", strconv.Itoa(u.Level()))
+}
+
+func (b *bridge) publishArea(a home.Area) {
+	b.publishField("area", a.Number, "state", areaState(a))
+}
+
+func (b *bridge) publishThermostat(t home.Thermostat) {
+	temp := func(v omni.Temperature) string {
+		return strconv.FormatFloat(v.In(b.format), 'f', 1, 64)
+	}
+	b.publishField("thermostat", t.Number, "temperature", temp(t.Temperature))
+	b.publishField("thermostat", t.Number, "heat_setpoint", temp(t.HeatSetPoint))
+	b.publishField("thermostat", t.Number, "cool_setpoint", temp(t.CoolSetPoint))
+	b.publishField("thermostat", t.Number, "humidity", strconv.Itoa(t.Humidity.Percent()))
+	b.publishField("thermostat", t.Number, "mode", thermostatModes[t.Mode])
+	b.publishField("thermostat", t.Number, "fan_mode", fanModes[t.FanMode])
+	b.publishField("thermostat", t.Number, "action", thermostatAction(t))
+}
+
+func (b *bridge) publishField(objectType string, number int, field, value string) {
+	b.publish(b.topic(objectType, number, field), value)
+}
+
+// publish queues a retained message. Messages are dropped while the broker is disconnected, and when the
+// queue is full.
+func (b *bridge) publish(topic, payload string) {
+	b.mu.Lock()
+	m := b.broker
+	b.mu.Unlock()
+	if m == nil {
+		return
+	}
+	select {
+	case b.queue <- message{topic: topic, payload: []byte(payload)}:
+	default:
+		b.logger.Printf("Dropped %s, too many messages waiting to be published", topic)
+	}
+}
+
+func (b *bridge) topic(objectType string, number int, field string) string {
+	return fmt.Sprintf("%s/%s/%d/%s", b.prefix, objectType, number, field)
+}
+
+// apply sends the command received on a topic to the controller.
+func (b *bridge) apply(ctx context.Context, topic, payload string) error {
+	parts := strings.Split(strings.TrimPrefix(topic, b.prefix+"/"), "/")
+	if len(parts) < 3 || parts[len(parts)-1] != "set" {
+		return errors.New("Not a command topic")
+	}
+	number, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return errors.Errorf("Invalid object number %q", parts[1])
+	}
+	field := strings.Join(parts[2:len(parts)-1], "/")
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	switch parts[0] {
+	case "unit":
+		return b.applyUnit(ctx, number, field, payload)
+	case "area":
+		return b.applyArea(ctx, number, field, payload)
+	case "thermostat":
+		return b.applyThermostat(ctx, number, field, payload)
+	}
+	return errors.Errorf("Unknown object type %q", parts[0])
+}
+
+func (b *bridge) applyUnit(ctx context.Context, number int, field, payload string) error {
+	switch field {
+	case "":
+		switch payload {
+		case "ON":
+			return b.client.UnitOn(ctx, number, 0)
+		case "OFF":
+			return b.client.UnitOff(ctx, number, 0)
+		}
+		return errors.Errorf("Unknown unit command %q", payload)
+	case "brightness":
+		level, err := strconv.Atoi(payload)
+		if err != nil {
+			return errors.Errorf("Invalid brightness %q", payload)
+		}
+		if level == 0 {
+			return b.client.UnitOff(ctx, number, 0)
+		}
+		return b.client.UnitLevel(ctx, number, level, 0)
+	}
+	return errors.Errorf("Unknown unit field %q", field)
+}
+
+// areaCommand is the payload of area commands. The code is the four digit security code, which is
+// validated to find the user code number the controller requires. A bare action is also accepted,
+// and is refused since it has no code.
+type areaCommand struct {
+	Action string `json:"action"`
+	Code   string `json:"code"`
+}
+
+var areaActions = map[string]omni.SecurityMode{
+	"DISARM":       omni.Disarmed,
+	"ARM_HOME":     omni.Day,
+	"ARM_NIGHT":    omni.Night,
+	"ARM_AWAY":     omni.Away,
+	"ARM_VACATION": omni.Vacation,
+}
+
+func (b *bridge) applyArea(ctx context.Context, number int, field, payload string) error {
+	if field != "" {
+		return errors.Errorf("Unknown area field %q", field)
+	}
+	cmd := areaCommand{Action: payload}
+	if strings.HasPrefix(payload, "{") {
+		err := json.Unmarshal([]byte(payload), &cmd)
+		if err != nil {
+			return errors.Wrap(err, "Invalid area command")
+		}
+	}
+	mode, ok := areaActions[cmd.Action]
+	if !ok {
+		return errors.Errorf("Unknown area action %q", cmd.Action)
+	}
+	if cmd.Code == "" {
+		return errors.New("Area commands require a code")
+	}
+	cv, err := b.client.ValidateCode(ctx, number, cmd.Code)
+	if err != nil {
+		return err
+	}
+	if cv.Authority == omni.InvalidCode {
+		return errors.Errorf("Invalid code for area %d", number)
+	}
+	return b.client.ArmArea(ctx, number, mode, int(cv.UserCode))
+}
+
+func (b *bridge) applyThermostat(ctx context.Context, number int, field, payload string) error {
+	switch field {
+	case "heat_setpoint", "cool_setpoint":
+		v, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return errors.Errorf("Invalid temperature %q", payload)
+		}
+		temp, err := omni.NewTemperature(v, b.format)
+		if err != nil {
+			return err
+		}
+		if field == "heat_setpoint" {
+			return b.client.SetThermostatHeatSetpoint(ctx, number, temp)
+		}
+		return b.client.SetThermostatCoolSetpoint(ctx, number, temp)
+	case "mode":
+		for mode, name := range thermostatModes {
+			if name == payload && mode != omni.ThermostatEmergencyHeat {
+				return b.client.SetThermostatMode(ctx, number, mode)
+			}
+		}
+		return errors.Errorf("Unknown thermostat mode %q", payload)
+	case "fan_mode":
+		for mode, name := range fanModes {
+			if name == payload {
+				return b.client.SetFanMode(ctx, number, mode)
+			}
+		}
+		return errors.Errorf("Unknown fan mode %q", payload)
+	}
+	return errors.Errorf("Unknown thermostat field %q", field)
+}
+
+// thermostatModes are the Home Assistant HVAC modes of the thermostat modes. Emergency heat is shown as heat.
+var thermostatModes = map[omni.ThermostatMode]string{
+	omni.ThermostatOff:           "off",
+	omni.ThermostatHeat:          "heat",
+	omni.ThermostatCool:          "cool",
+	omni.ThermostatAuto:          "heat_cool",
+	omni.ThermostatEmergencyHeat: "heat",
+}
+
+var fanModes = map[omni.FanMode]string{
+	omni.FanAuto:  "auto",
+	omni.FanOn:    "on",
+	omni.FanCycle: "cycle",
+}
+
+// areaState returns the Home Assistant alarm panel state of an area.
+func areaState(a home.Area) string {
+	switch {
+	case a.Alarms != 0:
+		return "triggered"
+	case a.EntryTimer > 0:
+		return "pending"
+	case a.ExitTimer > 0:
+		return "arming"
+	}
+	switch a.Mode {
+	case omni.Day, omni.DayInstant:
+		return "armed_home"
+	case omni.Night, omni.NightDelayed:
+		return "armed_night"
+	case omni.Away:
+		return "armed_away"
+	case omni.Vacation:
+		return "armed_vacation"
+	}
+	return "disarmed"
+}
+
+// thermostatAction returns the Home Assistant HVAC action of a thermostat.
+func thermostatAction(t home.Thermostat) string {
+	switch {
+	case t.Mode == omni.ThermostatOff:
+		return "off"
+	case t.ActionStatus&0x01 != 0:
+		return "heating"
+	case t.ActionStatus&0x02 != 0:
+		return "cooling"
+	}
+	return "idle"
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
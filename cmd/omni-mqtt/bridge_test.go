@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/home"
+	"github.com/leelynne/omnilink/omni/omnisim"
+)
+
+// newTestBridge starts a simulator of the demo installation and returns it with a bridge to it. Both are
+// closed by the returned function.
+func newTestBridge(t *testing.T) (*omnisim.Simulator, *bridge, func()) {
+	t.Helper()
+	sim, err := omnisim.ListenDemo(omnisim.Demo())
+	if err != nil {
+		t.Fatalf("Failed to start simulator: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := omni.NewClient(ctx, sim.Addr(), omnisim.DemoKey)
+	if err != nil {
+		sim.Close()
+		t.Fatalf("Failed to connect to simulator: %s", err)
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	h, err := home.NewFromClient(ctx, nil, c)
+	if err != nil {
+		c.Close()
+		sim.Close()
+		t.Fatalf("Failed to load home: %s", err)
+	}
+	return sim, newBridge(h, c, logger, "omni", discovery{}), func() {
+		c.Close()
+		sim.Close()
+	}
+}
+
+func celsius(v float64) omni.Temperature {
+	t, err := omni.NewTemperature(v, omni.Celsius)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestApply(t *testing.T) {
+	sim, b, done := newTestBridge(t)
+	defer done()
+	ctx, cancel := testMQTTContext()
+	defer cancel()
+
+	tests := []struct {
+		topic, payload string
+		check          func(inst *omnisim.Installation) bool
+	}{
+		{"omni/unit/1/set", "ON", func(inst *omnisim.Installation) bool { return inst.Units[1].State == 1 }},
+		{"omni/unit/1/brightness/set", "40", func(inst *omnisim.Installation) bool { return inst.Units[1].State == 140 }},
+		{"omni/unit/1/brightness/set", "0", func(inst *omnisim.Installation) bool { return inst.Units[1].State == 0 }},
+		{"omni/area/1/set", `{"action":"ARM_AWAY","code":"1234"}`, func(inst *omnisim.Installation) bool {
+			return inst.Areas[1].Mode == omni.Away
+		}},
+		{"omni/area/1/set", `{"action":"DISARM","code":"1234"}`, func(inst *omnisim.Installation) bool {
+			return inst.Areas[1].Mode == omni.Disarmed
+		}},
+		{"omni/thermostat/1/heat_setpoint/set", "19.5", func(inst *omnisim.Installation) bool {
+			return inst.Thermostats[1].HeatSetPoint == celsius(19.5)
+		}},
+		{"omni/thermostat/1/cool_setpoint/set", "24", func(inst *omnisim.Installation) bool {
+			return inst.Thermostats[1].CoolSetPoint == celsius(24)
+		}},
+		{"omni/thermostat/1/mode/set", "cool", func(inst *omnisim.Installation) bool {
+			return inst.Thermostats[1].SystemMode == omni.ThermostatCool
+		}},
+		{"omni/thermostat/1/fan_mode/set", "on", func(inst *omnisim.Installation) bool {
+			return inst.Thermostats[1].FanMode == omni.FanOn
+		}},
+	}
+	for _, test := range tests {
+		err := b.apply(ctx, test.topic, test.payload)
+		if err != nil {
+			t.Errorf("Applying %s %q failed: %s", test.topic, test.payload, err)
+			continue
+		}
+		var ok bool
+		sim.Update(func(inst *omnisim.Installation) { ok = test.check(inst) })
+		if !ok {
+			t.Errorf("Applying %s %q did not change the controller", test.topic, test.payload)
+		}
+	}
+
+	for _, test := range []struct{ topic, payload string }{
+		{"omni/unit/1/state", "ON"},
+		{"omni/unit/x/set", "ON"},
+		{"omni/door/1/set", "ON"},
+		{"omni/unit/1/set", "DIM"},
+		{"omni/area/1/set", "ARM_AWAY"},
+		{"omni/area/1/set", `{"action":"ARM_AWAY","code":"9999"}`},
+		{"omni/area/1/set", `{"action":"PANIC","code":"1234"}`},
+		{"omni/thermostat/1/heat_setpoint/set", "warm"},
+		{"omni/thermostat/1/mode/set", "emergency"},
+	} {
+		if err := b.apply(ctx, test.topic, test.payload); err == nil {
+			t.Errorf("Applying %s %q succeeded", test.topic, test.payload)
+		}
+	}
+	var mode omni.SecurityMode
+	sim.Update(func(inst *omnisim.Installation) { mode = inst.Areas[1].Mode })
+	if mode != omni.Disarmed {
+		t.Errorf("Area mode is %s after refused commands, want disarmed", mode)
+	}
+}
+
+// TestPublish connects the bridge to a broker and checks the retained state it publishes, that commands
+// from the broker are applied, and that the bridge publishes "offline" when it stops.
+func TestPublish(t *testing.T) {
+	sim, b, done := newTestBridge(t)
+	defer done()
+	broker := newFakeBroker(t, 0)
+	defer broker.Close()
+	ctx, cancel := testMQTTContext()
+	defer cancel()
+	go b.run(ctx)
+	stop := startServe(b, broker, brokerConfig{ClientID: "omni-mqtt-test"})
+
+	broker.published(t, map[string]string{
+		"omni/status":                     "online",
+		"omni/zone/1/state":               "OFF",
+		"omni/zone/2/state":               "ON",
+		"omni/unit/1/state":               "OFF",
+		"omni/area/1/state":               "disarmed",
+		"omni/thermostat/1/mode":          "heat_cool",
+		"omni/thermostat/1/heat_setpoint": "20.0",
+	})
+
+	broker.publish("omni/unit/1/set", "ON")
+	for on := false; !on; {
+		sim.Update(func(inst *omnisim.Installation) { on = inst.Units[1].State == 1 })
+		select {
+		case <-ctx.Done():
+			t.Fatal("Command from the broker was not applied")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	stop()
+	b.publish("omni/unit/1/state", "ON")
+	broker.published(t, map[string]string{"omni/status": "offline"})
+	broker.nextOf(t, mqttDisconnect)
+	select {
+	case p := <-broker.packets:
+		if p.header>>4 == mqttPublish {
+			topic, payload := publishPayload(t, p)
+			t.Errorf("Published %s %q after disconnecting", topic, payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	_, b, done := newTestBridge(t)
+	defer done()
+
+	d := discovery{prefix: "homeassistant", nodeID: "omni"}
+	configs := d.configs(b.home, "omni", omni.Celsius)
+	light, ok := configs["homeassistant/light/omni/unit_1/config"]
+	if !ok {
+		t.Fatal("No discovery config for unit 1")
+	}
+	// Home Assistant sends only the brightness when turning on a light, not ON followed by the brightness
+	if light["on_command_type"] != "brightness" || light["brightness_command_topic"] != "omni/unit/1/brightness/set" {
+		t.Errorf("Unit 1 discovery config is %v", light)
+	}
+	if _, ok := configs["homeassistant/climate/omni/thermostat_1/config"]; !ok {
+		t.Error("No discovery config for thermostat 1")
+	}
+}
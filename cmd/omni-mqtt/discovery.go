@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/home"
+)
+
+// discovery creates Home Assistant MQTT discovery configs, which let Home Assistant add the zones,
+// units, areas and thermostats as entities named after the objects' names on the controller.
+type discovery struct {
+	prefix string // Home Assistant discovery prefix, discovery is disabled if empty
+	nodeID string // Identifies the controller in unique ids and discovery topics
+}
+
+// configs returns the discovery configs of every object keyed by their discovery topics.
+func (d discovery) configs(h *home.Home, prefix string, format omni.TempFormat) map[string]map[string]interface{} {
+	model := h.ModelName
+	if model == "" {
+		model = fmt.Sprintf("Omni model %d", h.ModelNumber)
+	}
+	device := map[string]interface{}{
+		"identifiers":  []string{d.nodeID},
+		"name":         model,
+		"manufacturer": "HAI",
+		"model":        model,
+		"sw_version":   h.Version,
+	}
+	topic := func(objectType string, number int, field string) string {
+		return fmt.Sprintf("%s/%s/%d/%s", prefix, objectType, number, field)
+	}
+	configs := map[string]map[string]interface{}{}
+	add := func(component, objectType string, number int, name string, config map[string]interface{}) {
+		id := fmt.Sprintf("%s_%s_%d", d.nodeID, objectType, number)
+		config["name"] = name
+		config["unique_id"] = id
+		config["object_id"] = id
+		config["device"] = device
+		config["availability_topic"] = prefix + "/status"
+		configs[fmt.Sprintf("%s/%s/%s/%s_%d/config", d.prefix, component, d.nodeID, objectType, number)] = config
+	}
+
+	for _, z := range h.Zones() {
+		add("binary_sensor", "zone", z.Number, z.Name, map[string]interface{}{
+			"state_topic": topic("zone", z.Number, "state"),
+		})
+	}
+	for _, u := range h.Units() {
+		add("light", "unit", u.Number, u.Name, map[string]interface{}{
+			"state_topic":              topic("unit", u.Number, "state"),
+			"command_topic":            topic("unit", u.Number, "set"),
+			"brightness_state_topic":   topic("unit", u.Number, "brightness"),
+			"brightness_command_topic": topic("unit", u.Number, "brightness/set"),
+			"brightness_scale":         100,
+			"on_command_type":          "brightness",
+		})
+	}
+	for _, a := range h.Areas() {
+		add("alarm_control_panel", "area", a.Number, a.Name, map[string]interface{}{
+			"state_topic":        topic("area", a.Number, "state"),
+			"command_topic":      topic("area", a.Number, "set"),
+			"code":               "REMOTE_CODE",
+			"command_template":   `{"action":"{{ action }}","code":"{{ code }}"}`,
+			"supported_features": []string{"arm_home", "arm_away", "arm_night", "arm_vacation"},
+		})
+	}
+
+	unit, step := "F", 1.0
+	if format == omni.Celsius {
+		unit, step = "C", 0.5
+	}
+	for _, t := range h.Thermostats() {
+		add("climate", "thermostat", t.Number, t.Name, map[string]interface{}{
+			"current_temperature_topic":      topic("thermostat", t.Number, "temperature"),
+			"current_humidity_topic":         topic("thermostat", t.Number, "humidity"),
+			"temperature_low_state_topic":    topic("thermostat", t.Number, "heat_setpoint"),
+			"temperature_low_command_topic":  topic("thermostat", t.Number, "heat_setpoint/set"),
+			"temperature_high_state_topic":   topic("thermostat", t.Number, "cool_setpoint"),
+			"temperature_high_command_topic": topic("thermostat", t.Number, "cool_setpoint/set"),
+			"mode_state_topic":               topic("thermostat", t.Number, "mode"),
+			"mode_command_topic":             topic("thermostat", t.Number, "mode/set"),
+			"modes":                          []string{"off", "heat", "cool", "heat_cool"},
+			"fan_mode_state_topic":           topic("thermostat", t.Number, "fan_mode"),
+			"fan_mode_command_topic":         topic("thermostat", t.Number, "fan_mode/set"),
+			"fan_modes":                      []string{"auto", "on", "cycle"},
+			"action_topic":                   topic("thermostat", t.Number, "action"),
+			"temperature_unit":               unit,
+			"temp_step":                      step,
+			"precision":                      step,
+		})
+	}
+	return configs
+}
@@ -0,0 +1,140 @@
+// Command omni-mqtt bridges an Omni controller to an MQTT broker. It publishes the state of the
+// controller's named zones, units, areas and thermostats as retained messages, applies the commands
+// published to their command topics, and publishes Home Assistant MQTT discovery configs so they appear
+// in Home Assistant as binary sensors, lights, alarm panels and climate entities.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/home"
+	"github.com/leelynne/omnilink/omni/proto"
+)
+
+func main() {
+	logger := log.New(os.Stderr, "omni-mqtt: ", log.LstdFlags)
+	var endpoint, key, broker, user, password, prefix, discoveryPrefix, nodeID string
+	var useTLS bool
+	var refresh time.Duration
+	flag.StringVar(&endpoint, "endpoint", "", "controller address to connect to, port 4369 is used if none is given")
+	flag.StringVar(&key, "key", os.Getenv("OMNI_KEY"), "controller key, defaults to $OMNI_KEY")
+	flag.StringVar(&broker, "broker", "localhost:1883", "MQTT broker address")
+	flag.StringVar(&user, "user", "", "MQTT user name")
+	flag.StringVar(&password, "password", os.Getenv("MQTT_PASSWORD"), "MQTT password, defaults to $MQTT_PASSWORD")
+	flag.BoolVar(&useTLS, "tls", false, "connect to the broker with TLS")
+	flag.StringVar(&prefix, "prefix", "omni", "prefix of the state and command topics")
+	flag.StringVar(&discoveryPrefix, "discovery-prefix", "homeassistant", "Home Assistant discovery prefix, empty disables discovery")
+	flag.StringVar(&nodeID, "node-id", "omni", "identifies the controller in Home Assistant, must be unique for each bridge")
+	flag.DurationVar(&refresh, "refresh", 5*time.Minute, "interval at which every object is polled to catch missed changes")
+	flag.Parse()
+	if endpoint == "" || key == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	// Connection states are passed on once the bridge exists
+	online := make(chan bool, 16)
+	c, err := omni.NewClient(ctx, omni.ControllerAddr(endpoint), key,
+		omni.WithLogger(proto.StdLogger{Logger: logger}),
+		omni.WithReconnect(time.Second, time.Minute),
+		omni.WithStateFunc(func(state omni.ConnState, err error) {
+			select {
+			case online <- state == omni.Secure:
+			default:
+			}
+		}),
+	)
+	if err != nil {
+		logger.Fatalf("Failed to connect to controller: %s", err)
+	}
+	defer c.Close()
+
+	h, err := home.NewFromClient(ctx, proto.StdLogger{Logger: logger}, c)
+	if err != nil {
+		logger.Fatalf("Failed to load controller: %s", err)
+	}
+	b := newBridge(h, c, logger, prefix, discovery{prefix: discoveryPrefix, nodeID: nodeID})
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ok := <-online:
+				b.setOnline(ok)
+			}
+		}
+	}()
+	go b.run(ctx)
+	go func() {
+		err := h.Run(ctx, refresh)
+		if ctx.Err() == nil {
+			logger.Printf("Stopped following controller: %s", err)
+			cancel()
+		}
+	}()
+
+	conf := brokerConfig{
+		Addr:     broker,
+		ClientID: "omni-mqtt-" + nodeID,
+		Username: user,
+		Password: password,
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(broker)
+		conf.TLS = &tls.Config{ServerName: host}
+	}
+	serve(ctx, logger, b, mqtt.NewClient(mqttOptions(b, conf)), broker)
+}
+
+// serve connects to the broker, retrying with a backoff until the first connection succeeds, and stays
+// connected until ctx is done. The client reconnects by itself if the connection is later lost.
+func serve(ctx context.Context, logger *log.Logger, b *bridge, m mqtt.Client, broker string) {
+	backoff := time.Second
+	for {
+		t := m.Connect()
+		select {
+		case <-t.Done():
+		case <-ctx.Done():
+			return
+		}
+		err := t.Error()
+		if err == nil {
+			break
+		}
+		logger.Printf("Failed to connect to broker %s: %s, retrying in %s", broker, err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+
+	<-ctx.Done()
+	b.detach(m)
+	wait(m.Publish(b.prefix+"/status", 0, true, "offline"))
+	m.Disconnect(uint(mqttTimeout / time.Millisecond))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+// Every message is published and subscribed at QoS 0 with a clean session. The bridge republishes its
+// retained state each time it connects, so nothing is lost by not persisting the session.
+
+const (
+	mqttKeepAlive = 30 * time.Second
+	// mqttTimeout bounds each write to the broker and the wait for its acknowledgements, so a broker
+	// which stops reading cannot block the bridge.
+	mqttTimeout = 10 * time.Second
+)
+
+// brokerConfig configures the session with an MQTT broker.
+type brokerConfig struct {
+	Addr     string      // host:port
+	TLS      *tls.Config // Connect with TLS if set
+	ClientID string
+	Username string
+	Password string
+}
+
+// mqttOptions returns the options of a session which attaches b to the broker each time it connects and
+// reconnects when the connection is lost. The broker publishes "offline" to the status topic if the
+// connection is lost.
+func mqttOptions(b *bridge, conf brokerConfig) *mqtt.ClientOptions {
+	scheme := "tcp"
+	if conf.TLS != nil {
+		scheme = "ssl"
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(scheme+"://"+conf.Addr).
+		SetClientID(conf.ClientID).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetTLSConfig(conf.TLS).
+		SetProtocolVersion(4). // MQTT 3.1.1, rather than falling back to 3.1 when refused
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAlive).
+		SetConnectTimeout(mqttTimeout).
+		SetWriteTimeout(mqttTimeout).
+		SetBinaryWill(b.prefix+"/status", []byte("offline"), 0, true).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(time.Minute)
+	opts.SetOnConnectHandler(func(m mqtt.Client) {
+		b.logger.Printf("Connected to broker %s", conf.Addr)
+		err := b.attach(m)
+		if err != nil {
+			b.logger.Printf("Failed to attach to broker: %s", err)
+		}
+	})
+	opts.SetConnectionLostHandler(func(m mqtt.Client, err error) {
+		b.detach(m)
+		b.logger.Printf("Lost connection to broker %s: %s", conf.Addr, err)
+	})
+	return opts
+}
+
+// wait waits for the broker to complete an operation.
+func wait(t mqtt.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return errors.New("Timed out waiting for broker")
+	}
+	return t.Error()
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// The MQTT 3.1.1 packet types which fakeBroker handles.
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSubAck     = 9
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttPacket is a packet received by fakeBroker.
+type mqttPacket struct {
+	header byte
+	body   []byte
+}
+
+// fakeBroker stands in for an MQTT broker. It accepts one session at a time, refusing the first refuse
+// sessions, acknowledges subscriptions and pings, and sends each packet received on packets.
+type fakeBroker struct {
+	ln      net.Listener
+	packets chan mqttPacket
+
+	mu     sync.Mutex
+	refuse int
+	conn   net.Conn // The current session
+}
+
+func newFakeBroker(t *testing.T, refuse int) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{
+		ln:      ln,
+		packets: make(chan mqttPacket, 1000),
+		refuse:  refuse,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conn = conn
+			b.mu.Unlock()
+			b.serve(conn)
+		}
+	}()
+	return b
+}
+
+// serve handles a session until the client disconnects or the session is dropped.
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header, body, err := readMQTTPacket(conn)
+		if err != nil {
+			return
+		}
+		b.packets <- mqttPacket{header: header, body: body}
+		switch header >> 4 {
+		case mqttConnect:
+			b.mu.Lock()
+			code := byte(0)
+			if b.refuse > 0 {
+				b.refuse--
+				code = 5 // Not authorized
+			}
+			b.mu.Unlock()
+			writeMQTTPacket(conn, mqttConnAck<<4, []byte{0, code})
+		case mqttSubscribe:
+			ack := append([]byte{}, body[:2]...)
+			for rest := body[2:]; len(rest) > 0; {
+				_, rest, _ = readString(rest)
+				rest = rest[1:]
+				ack = append(ack, 0)
+			}
+			writeMQTTPacket(conn, mqttSubAck<<4, ack)
+		case mqttPingReq:
+			writeMQTTPacket(conn, mqttPingResp<<4, nil)
+		}
+	}
+}
+
+func (b *fakeBroker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *fakeBroker) Close() {
+	b.ln.Close()
+	b.drop()
+}
+
+// drop closes the current session without a DISCONNECT, as if the connection was lost.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+}
+
+// publish sends a QoS 0 message to the client of the current session.
+func (b *fakeBroker) publish(topic, payload string) {
+	body := &bytes.Buffer{}
+	writeString(body, topic)
+	body.WriteString(payload)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	writeMQTTPacket(b.conn, mqttPublish<<4, body.Bytes())
+}
+
+// next returns the next packet the client sent.
+func (b *fakeBroker) next(t *testing.T) mqttPacket {
+	t.Helper()
+	select {
+	case p := <-b.packets:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a packet from the client")
+	}
+	return mqttPacket{}
+}
+
+// nextOf skips packets until the client sends one of the given type.
+func (b *fakeBroker) nextOf(t *testing.T, packetType byte) mqttPacket {
+	t.Helper()
+	for {
+		if p := b.next(t); p.header>>4 == packetType {
+			return p
+		}
+	}
+}
+
+// published reads PUBLISH packets until every topic in want has been published, checking that each
+// was retained with the wanted payload.
+func (b *fakeBroker) published(t *testing.T, want map[string]string) {
+	t.Helper()
+	for len(want) > 0 {
+		p := b.nextOf(t, mqttPublish)
+		if p.header&0x01 == 0 {
+			t.Errorf("Published %x without retain", p.body)
+		}
+		topic, payload := publishPayload(t, p)
+		if v, ok := want[topic]; ok {
+			if payload != v {
+				t.Errorf("Published %s %q, want %q", topic, payload, v)
+			}
+			delete(want, topic)
+		}
+	}
+}
+
+// publishPayload splits the body of a QoS 0 PUBLISH packet.
+func publishPayload(t *testing.T, p mqttPacket) (string, string) {
+	t.Helper()
+	topic, payload, ok := readString(p.body)
+	if !ok {
+		t.Fatalf("Malformed PUBLISH %v", p.body)
+	}
+	return topic, string(payload)
+}
+
+// readMQTTPacket reads a packet, returning its fixed header byte and the rest of the packet.
+func readMQTTPacket(r io.Reader) (byte, []byte, error) {
+	b := []byte{0}
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return 0, nil, err
+	}
+	header := b[0]
+	length := 0
+	for shift := uint(0); ; shift += 7 {
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b[0]&0x7F) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	pkt := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(pkt, body...))
+	return err
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+// readString reads a length prefixed string, returning the bytes after it.
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+func testMQTTContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 10*time.Second)
+}
+
+// startServe runs serve for b against broker until the returned function is called, which waits for
+// serve to return.
+func startServe(b *bridge, broker *fakeBroker, conf brokerConfig) func() {
+	conf.Addr = broker.Addr()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(ctx, b.logger, b, mqtt.NewClient(mqttOptions(b, conf)), conf.Addr)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// TestMQTTSession checks the session the bridge opens with the broker and the subscriptions it makes.
+func TestMQTTSession(t *testing.T) {
+	_, b, done := newTestBridge(t)
+	defer done()
+	b.discov.prefix = "homeassistant"
+	broker := newFakeBroker(t, 0)
+	defer broker.Close()
+	stop := startServe(b, broker, brokerConfig{ClientID: "omni-mqtt-test", Username: "user", Password: "secret"})
+	defer stop()
+
+	connect := broker.nextOf(t, mqttConnect)
+	protoName, rest, _ := readString(connect.body)
+	if protoName != "MQTT" || rest[0] != 4 {
+		t.Errorf("Connected with protocol %q level %d", protoName, rest[0])
+	}
+	// Username, password, will retain, will and clean session
+	if rest[1] != 0xE6 {
+		t.Errorf("Connected with flags %#x, want 0xe6", rest[1])
+	}
+	if keepAlive := binary.BigEndian.Uint16(rest[2:]); keepAlive != 30 {
+		t.Errorf("Connected with keep alive %d, want 30", keepAlive)
+	}
+	var fields []string
+	for rest = rest[4:]; len(rest) > 0; {
+		var s string
+		var ok bool
+		s, rest, ok = readString(rest)
+		if !ok {
+			t.Fatalf("Malformed CONNECT payload %v", connect.body)
+		}
+		fields = append(fields, s)
+	}
+	if got := strings.Join(fields, ","); got != "omni-mqtt-test,omni/status,offline,user,secret" {
+		t.Errorf("Connected with payload %s", got)
+	}
+
+	sub := broker.nextOf(t, mqttSubscribe)
+	var filters []string
+	for rest := sub.body[2:]; len(rest) > 0; {
+		var f string
+		var ok bool
+		f, rest, ok = readString(rest)
+		if !ok || len(rest) == 0 || rest[0] != 0 {
+			t.Fatalf("Malformed SUBSCRIBE payload %v", sub.body)
+		}
+		filters = append(filters, f)
+		rest = rest[1:]
+	}
+	sort.Strings(filters)
+	if want := []string{"homeassistant/status", "omni/+/+/+/set", "omni/+/+/set"}; !reflect.DeepEqual(filters, want) {
+		t.Errorf("Subscribed to %q at QoS 0, want %q", filters, want)
+	}
+}
+
+// TestMQTTReconnect drops the broker connection, which the client must re-establish, subscribing and
+// publishing the state again.
+func TestMQTTReconnect(t *testing.T) {
+	_, b, done := newTestBridge(t)
+	defer done()
+	ctx, cancel := testMQTTContext()
+	defer cancel()
+	go b.run(ctx)
+	broker := newFakeBroker(t, 0)
+	defer broker.Close()
+	stop := startServe(b, broker, brokerConfig{ClientID: "omni-mqtt-test"})
+	defer stop()
+
+	broker.published(t, map[string]string{"omni/status": "online"})
+	broker.drop()
+	broker.nextOf(t, mqttConnect)
+	broker.nextOf(t, mqttSubscribe)
+	broker.published(t, map[string]string{"omni/status": "online", "omni/unit/1/state": "OFF"})
+}
+
+// TestServeRetry refuses the first connection, which serve must retry.
+func TestServeRetry(t *testing.T) {
+	_, b, done := newTestBridge(t)
+	defer done()
+	ctx, cancel := testMQTTContext()
+	defer cancel()
+	go b.run(ctx)
+	broker := newFakeBroker(t, 1)
+	defer broker.Close()
+	stop := startServe(b, broker, brokerConfig{ClientID: "omni-mqtt-test"})
+	defer stop()
+
+	broker.nextOf(t, mqttConnect)
+	broker.nextOf(t, mqttConnect)
+	broker.published(t, map[string]string{"omni/status": "online"})
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/proto"
 )
 
 func main() {
@@ -14,17 +16,25 @@ func main() {
 	var endpoint string
 	var key string
 	flag.StringVar(&endpoint, "endpoint", "", "endpoint to connect to.")
+	var trace bool
 	flag.StringVar(&key, "key", "", "client key")
+	flag.BoolVar(&trace, "trace", false, "log every packet exchanged with the controller")
 	flag.Parse()
-	c, err := omni.NewClient(fmt.Sprintf("%s:4369", endpoint), key)
+	ctx := context.Background()
+	opts := []omni.Option{omni.WithLogger(proto.StdLogger{Logger: logger})}
+	if trace {
+		opts = append(opts, omni.WithTrace())
+	}
+	c, err := omni.NewClient(ctx, fmt.Sprintf("%s:4369", endpoint), key, opts...)
 
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
+	defer c.Close()
 
 	logger.Printf("Connected!")
-	si, err := c.GetSystemInformation()
+	si, err := c.GetSystemInformation(ctx)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
@@ -32,53 +42,51 @@ func main() {
 	fmt.Printf("SysINFO %+v\n", si)
 	fmt.Printf("Phone '%s'\n", string(si.LocalPhoneNumber[:]))
 
-	st, err := c.GetSystemStatus()
+	st, err := c.GetSystemStatus(ctx)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
 	fmt.Printf("SysSTATUS %+v\n", st)
 
-	tr, err := c.GetSystemTroubles()
+	tr, err := c.GetSystemTroubles(ctx)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
 	fmt.Printf("SysTroubles %+v\n", tr)
 
-	ftr, err := c.GetSystemFeatures()
+	ftr, err := c.GetSystemFeatures(ctx)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
 	fmt.Printf("SysFeatures %+v\n", ftr)
 
-	form, err := c.GetSystemFormats()
+	form, err := c.GetSystemFormats(ctx)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
 	fmt.Printf("SysFormats %+v\n", form)
 
-	otc, err := c.GetObjectTypeCapacity(omni.Zone)
+	otc, err := c.GetObjectTypeCapacity(ctx, omni.Zone)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
 	fmt.Printf("Capacity %+v\n\n", otc)
 
-	thermProps, numFound, err := c.GetObjectProperties(omni.Thermostat)
+	tprops, err := c.Thermostats(ctx)
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
 	}
-	if tprops, ok := thermProps.([]omni.ThermostatProperties); ok {
-		for _, tprop := range tprops {
-			fmt.Printf("ThermoName %s\n", string(tprop.Name[:]))
-		}
+	for _, tprop := range tprops {
+		fmt.Printf("ThermoName %s\n", string(tprop.Name[:]))
 	}
 
-	ostat, err := c.GetObjectStatus(omni.Thermostat, numFound)
+	ostat, err := c.ThermostatStatus(ctx, omni.Range{Start: 1, End: len(tprops)})
 	if err != nil {
 		fmt.Printf("%+v\n", err)
 		panic(err)
@@ -1,7 +1,9 @@
 module github.com/leelynne/omnilink
 
 require (
-	github.com/pkg/errors v0.8.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.9.0
 	golang.org/x/tools/gopls v0.6.1 // indirect
 )
 
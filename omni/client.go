@@ -1,72 +1,276 @@
 package omni
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/leelynne/omnilink/omni/proto"
 	"github.com/pkg/errors"
 )
 
+// defaultTimeout bounds a request and its reply when the caller's context has no deadline.
+const defaultTimeout = 20 * time.Second
+
+// maxMsgLength is the largest value of the message length field, which covers the message type and data.
+const maxMsgLength = 255
+
+// DefaultPort is the port controllers listen on unless configured otherwise.
+const DefaultPort = "4369"
+
+// ControllerAddr adds DefaultPort to endpoint if it has no port.
+func ControllerAddr(endpoint string) string {
+	if _, _, err := net.SplitHostPort(endpoint); err == nil {
+		return endpoint
+	}
+	return net.JoinHostPort(endpoint, DefaultPort)
+}
+
 // Client is an Omni-link II client.
 type Client struct {
-	Addr string // IP:Port
-	conn proto.Conn
+	Addr     string // IP:Port
+	key      proto.StaticKey
+	connect  func(ctx context.Context, addr string, key proto.StaticKey, opts ...proto.Option) (proto.Conn, error)
+	connOpts []proto.Option
+	logger   Logger
+
+	reconnect  bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	stateFunc  func(ConnState, error)
+
+	mu     sync.Mutex
+	conn   proto.Conn
+	ready  chan struct{} // Closed when conn is replaced by a new session
+	closed bool
+	stop   chan struct{} // Closed by Close
 }
 
-// NewClient returns a Client
-func NewClient(addr string, key string) (*Client, error) {
-	skey, err := parseKey(key)
+// NewClient returns a Client connected to the controller. The context bounds the connection handshake.
+func NewClient(ctx context.Context, addr string, key string, opts ...Option) (*Client, error) {
+	skey, err := ParseKey(key)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := proto.NewConnection(addr, skey)
+	c := &Client{
+		Addr:    addr,
+		key:     skey,
+		connect: proto.NewConnection,
+		logger:  proto.NopLogger{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	err = c.start(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return c, nil
+}
 
-	return &Client{
-		Addr: addr,
-		conn: conn,
-	}, nil
+// NewSerialClient returns a Client connected to the controller's serial port, such as an opened tty device,
+// which logs in with a four digit user code. The context bounds the login. WithReconnect has no effect on
+// serial clients.
+func NewSerialClient(ctx context.Context, rwc io.ReadWriteCloser, code string, opts ...Option) (*Client, error) {
+	c := &Client{
+		Addr:   "serial",
+		logger: proto.NopLogger{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.reconnect = false
+	c.connect = func(ctx context.Context, addr string, key proto.StaticKey, opts ...proto.Option) (proto.Conn, error) {
+		return proto.NewSerialConnection(ctx, rwc, code, opts...)
+	}
+	err := c.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClientFromConn returns a Client which sends requests on an established connection, such as one
+// returned by proto.NewReplayConn to replay a recorded session. WithReconnect and the options which
+// configure connections have no effect.
+func NewClientFromConn(conn proto.Conn, opts ...Option) (*Client, error) {
+	c := &Client{
+		Addr:   "conn",
+		logger: proto.NopLogger{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.reconnect = false
+	c.connect = func(ctx context.Context, addr string, key proto.StaticKey, opts ...proto.Option) (proto.Conn, error) {
+		return conn, nil
+	}
+	err := c.start(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// start creates the first session with the controller.
+func (c *Client) start(ctx context.Context) error {
+	c.ready = make(chan struct{})
+	c.stop = make(chan struct{})
+
+	c.setState(Connecting, nil)
+	conn, err := c.connect(ctx, c.Addr, c.key, c.connOpts...)
+	if err != nil {
+		c.setState(Lost, err)
+		return err
+	}
+	c.conn = conn
+	c.setState(Secure, nil)
+
+	if c.reconnect {
+		go c.maintain(conn)
+	}
+	return nil
+}
+
+// Close ends the session with the controller and stops reconnecting. Close can be called multiple times.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.stop)
+	conn := c.conn
+	c.mu.Unlock()
+
+	return conn.Close()
 }
 
-func (c *Client) GetSystemInformation() (SystemInfo, error) {
+func (c *Client) GetSystemInformation(ctx context.Context) (SystemInfo, error) {
 	si := SystemInfo{}
 	m := &proto.Msg{Type: proto.MsgReqSystemInfo}
 
-	resp, err := c.sendMessage(m)
+	resp, err := c.sendMessage(ctx, m)
 	if err != nil {
-		return si, nil
+		return si, errors.Wrap(err, "Failed to get system information")
+	}
+	if resp.Type != proto.MsgSystemInfo {
+		return si, replyError(m, resp, "report system information")
 	}
 
 	err = unmarshalMessage(resp, &si)
 	return si, err
 }
 
-func (c *Client) GetSystemStatus() (SystemStatus, error) {
+func (c *Client) GetSystemStatus(ctx context.Context) (SystemStatus, error) {
 	st := SystemStatus{}
 	m := &proto.Msg{Type: proto.MsgReqSystemStatus}
 
-	resp, err := c.sendMessage(m)
+	resp, err := c.sendMessage(ctx, m)
 	if err != nil {
-		return st, nil
+		return st, errors.Wrap(err, "Failed to get system status")
+	}
+	if resp.Type != proto.MsgSystemStatus {
+		return st, replyError(m, resp, "report system status")
 	}
 
 	err = unmarshalMessage(resp, &st)
 	return st, err
 }
 
-func (c *Client) GetSystemTroubles() (SystemTroubles, error) {
+// SetTime sets the controller's date and time to t in t's location. The daylight savings time flag is
+// set if daylight savings time is in effect at t.
+func (c *Client) SetTime(ctx context.Context, t time.Time) error {
+	if t.Year() < 2000 || t.Year() > 2099 {
+		return errors.Errorf("Controller cannot be set to year %d", t.Year())
+	}
+	weekday := int(t.Weekday()) // The controller numbers days from 1 for Monday to 7 for Sunday
+	if weekday == 0 {
+		weekday = 7
+	}
+	data := []byte{
+		uint8(t.Year() - 2000),
+		uint8(t.Month()),
+		uint8(t.Day()),
+		uint8(weekday),
+		uint8(t.Hour()),
+		uint8(t.Minute()),
+		0,
+	}
+	if isDST(t) {
+		data[6] = 1
+	}
+	m := &proto.Msg{Type: proto.MsgSetTime, Data: data}
+
+	resp, err := c.sendMessage(ctx, m)
+	if err != nil {
+		return errors.Wrap(err, "Failed to set time")
+	}
+	if resp.Type != proto.MsgAck {
+		return replyError(m, resp, "set time to "+t.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// isDST reports whether daylight savings time is in effect at t. Locations without daylight savings
+// time have the same offset throughout the year, and their standard offset is the smaller one.
+func isDST(t time.Time) bool {
+	_, jan := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()).Zone()
+	_, jul := time.Date(t.Year(), time.July, 1, 0, 0, 0, 0, t.Location()).Zone()
+	_, off := t.Zone()
+	std := jan
+	if jul < std {
+		std = jul
+	}
+	return jan != jul && off != std
+}
+
+// ValidateCode checks that a four digit security code is valid and time-enabled in an area. Invalid codes
+// are not an error, they are reported with the InvalidCode authority level.
+func (c *Client) ValidateCode(ctx context.Context, area int, code string) (CodeValidation, error) {
+	cv := CodeValidation{}
+	if len(code) != 4 {
+		return cv, errors.Errorf("Code must be four digits")
+	}
+	data := []byte{uint8(area)}
+	for _, d := range code {
+		if d < '0' || d > '9' {
+			return cv, errors.Errorf("Code must be four digits")
+		}
+		data = append(data, byte(d-'0'))
+	}
+	m := &proto.Msg{Type: proto.MsgReqCodeValidation, Data: data}
+
+	resp, err := c.sendMessage(ctx, m)
+	if err != nil {
+		return cv, errors.Wrap(err, "Failed to validate security code")
+	}
+	if resp.Type != proto.MsgCodeValidation {
+		return cv, replyError(m, resp, "validate security code")
+	}
+
+	err = unmarshalMessage(resp, &cv)
+	return cv, err
+}
+
+func (c *Client) GetSystemTroubles(ctx context.Context) (SystemTroubles, error) {
 	m := &proto.Msg{Type: proto.MsgReqSystemTroubles}
 
-	resp, err := c.sendMessage(m)
+	resp, err := c.sendMessage(ctx, m)
 	if err != nil {
 		return SystemTroubles{}, errors.Wrap(err, "Failed to get system troubles")
 	}
+	if resp.Type != proto.MsgSystemTroubles {
+		return SystemTroubles{}, replyError(m, resp, "report system troubles")
+	}
 
 	numTroubles := len(resp.Data)
 	troubles := make([]SystemTrouble, numTroubles)
@@ -78,13 +282,16 @@ func (c *Client) GetSystemTroubles() (SystemTroubles, error) {
 	}, nil
 }
 
-func (c *Client) GetSystemFeatures() (SystemFeatures, error) {
+func (c *Client) GetSystemFeatures(ctx context.Context) (SystemFeatures, error) {
 	m := &proto.Msg{Type: proto.MsgReqSystemFeatures}
 
-	resp, err := c.sendMessage(m)
+	resp, err := c.sendMessage(ctx, m)
 	if err != nil {
 		return SystemFeatures{}, errors.Wrap(err, "Failed to get system features")
 	}
+	if resp.Type != proto.MsgSystemFeatures {
+		return SystemFeatures{}, replyError(m, resp, "report system features")
+	}
 
 	numFeatures := len(resp.Data)
 
@@ -97,125 +304,193 @@ func (c *Client) GetSystemFeatures() (SystemFeatures, error) {
 	}, nil
 }
 
-func (c *Client) GetSystemFormats() (SystemFormats, error) {
+func (c *Client) GetSystemFormats(ctx context.Context) (SystemFormats, error) {
 	m := &proto.Msg{Type: proto.MsgReqSystemFormats}
 
-	resp, err := c.sendMessage(m)
+	resp, err := c.sendMessage(ctx, m)
 	if err != nil {
 		return SystemFormats{}, errors.Wrap(err, "Failed to get system formats")
 	}
+	if resp.Type != proto.MsgSystemFormats {
+		return SystemFormats{}, replyError(m, resp, "report system formats")
+	}
 
 	sf := SystemFormats{}
 	err = unmarshalMessage(resp, &sf)
 	return sf, err
 }
 
-func (c *Client) GetObjectTypeCapacity(t ObjectType) (ObjectTypeCapacities, error) {
+func (c *Client) GetObjectTypeCapacity(ctx context.Context, t ObjectType) (ObjectTypeCapacities, error) {
 	m := &proto.Msg{
 		Type: proto.MsgReqObjectTypeCapacities,
 		Data: []byte{byte(t)},
 	}
 
-	resp, err := c.sendMessage(m)
+	resp, err := c.sendMessage(ctx, m)
 	if err != nil {
 		return ObjectTypeCapacities{}, errors.Wrapf(err, "Failed to get object type capacity for type %s", t)
 	}
+	if resp.Type != proto.MsgObjectTypeCapacities {
+		return ObjectTypeCapacities{}, replyError(m, resp, fmt.Sprintf("report %s capacity", t))
+	}
 
 	otc := ObjectTypeCapacities{}
 	err = unmarshalMessage(resp, &otc)
 	return otc, err
 }
 
-func (c *Client) GetObjectProperties(objectType ObjectType) (properties interface{}, numObject int, e error) {
+// objectProperties requests the properties of every object of the given type and returns one reply per object.
+func (c *Client) objectProperties(ctx context.Context, objectType ObjectType) ([]*proto.Msg, error) {
 	msgs := []*proto.Msg{}
-	for lsb := 0; true; lsb++ {
+	for index := 0; ; {
 		m := &proto.Msg{
 			Type: proto.MsgReqObjectProperties,
 			Data: []byte{
 				byte(objectType),
-				byte(0),
-				byte(lsb),
-				byte(1),
+				byte(index >> 8),
+				byte(index),
+				byte(1), // Next object after index
 				byte(0),
 				byte(255), // Area
 				byte(0),
 			},
 		}
 
-		resp, err := c.sendMessage(m)
+		resp, err := c.sendMessage(ctx, m)
 
 		if err != nil {
-			return ObjectProperties{}, 0, errors.Wrap(err, "Failed to get object property")
+			return nil, errors.Wrapf(err, "Failed to get %s properties", objectType)
 		}
-		if len(resp.Data) <= 1 {
+		if resp.Type == proto.MsgEndOfData {
 			break
 		}
+		if resp.Type != proto.MsgObjectProperties {
+			return nil, replyError(m, resp, fmt.Sprintf("report %s properties", objectType))
+		}
+		if len(resp.Data) < 3 {
+			return nil, errors.Errorf("Missing object number in %s properties", objectType)
+		}
+		next := int(resp.Data[1])<<8 | int(resp.Data[2])
+		if next <= index {
+			break
+		}
+		index = next
 		msgs = append(msgs, resp)
 	}
-	var out interface{}
-	switch objectType {
-	case Thermostat:
-		tprops := []ThermostatProperties{}
-		for _, msg := range msgs {
-			tprop := ThermostatProperties{}
-			err := unmarshalMessage(msg, &tprop)
-			if err != nil {
-				return nil, 0, errors.Wrap(err, "Failed to marshal data into Property")
-			}
-			tprops = append(tprops, tprop)
-		}
-		out = tprops
-		numObject = len(tprops)
+	return msgs, nil
+}
+
+// statusRecords returns the status records for objects in the range. Large ranges are split across several requests.
+func (c *Client) statusRecords(ctx context.Context, objectType ObjectType, r Range) ([]byte, error) {
+	// A reply holds at most 255 bytes of message type, object type and status records
+	perRequest := (maxMsgLength - 2) / StatusSizes[objectType]
+	resps, err := c.requestStatus(ctx, proto.MsgReqObjectStatus, proto.MsgObjectStatus, objectType, r, perRequest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get %s status", objectType)
 	}
 
-	return out, numObject, nil
+	records := []byte{}
+	for _, resp := range resps {
+		records = append(records, resp.Data[1:]...)
+	}
+	return records, nil
 }
 
-func (c *Client) GetObjectStatus(objectType ObjectType, numObjects int) (interface{}, error) {
-	m := &proto.Msg{
-		Type: proto.MsgReqObjectStatus,
-		Data: []byte{
-			byte(objectType),
-			byte(0),
-			byte(1),
-			byte(0),
-			byte(numObjects - 1),
-		},
-	}
+// extendedStatusRecords returns the extended status records, which requires controller firmware 3.0 or later,
+// for objects in the range. Each record is resized to ExtendedStatusSizes for the object type.
+func (c *Client) extendedStatusRecords(ctx context.Context, objectType ObjectType, r Range) ([]byte, error) {
+	statusSize := ExtendedStatusSizes[objectType]
 
-	resp, err := c.sendMessage(m)
+	// A reply holds at most 255 bytes of message type, object type, record length and status records
+	perRequest := (maxMsgLength - 3) / statusSize
+	resps, err := c.requestStatus(ctx, proto.MsgReqExtendedObjectStatus, proto.MsgExtendedObjectStatus, objectType, r, perRequest)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to get object status")
+		return nil, errors.Wrapf(err, "Failed to get %s extended status", objectType)
 	}
 
-	reader := resp.Reader()
-	var respType uint8
-	binary.Read(reader, binary.LittleEndian, &respType)
-	if respType != uint8(objectType) {
-		return nil, errors.Errorf("Wrong return typed '%d' for input type '%d'", respType, objectType)
+	// Records may be longer than the fields known here, or shorter on older firmware, so
+	// copy each one into a record of the expected size before decoding.
+	records := []byte{}
+	for _, resp := range resps {
+		if len(resp.Data) < 2 || resp.Data[1] == 0 {
+			return nil, errors.Errorf("Missing record length in extended status for type %s", objectType)
+		}
+		recordLen := int(resp.Data[1])
+		data := resp.Data[2:]
+		for i := 0; i+recordLen <= len(data); i += recordLen {
+			record := make([]byte, statusSize)
+			copy(record, data[i:i+recordLen])
+			records = append(records, record...)
+		}
 	}
+	return records, nil
+}
 
-	statusSize := StatusSizes[objectType]
-	total := len(resp.Data) / statusSize
-
-	var out interface{}
-	switch objectType {
-	case Thermostat:
-		otc := make([]ThermostatStatus, total)
-		err = binary.Read(reader, binary.LittleEndian, &otc)
-		out = otc
+// requestStatus sends status requests of the given type for objects in the range, perRequest objects at a time,
+// and returns the replies.
+func (c *Client) requestStatus(ctx context.Context, req, reply proto.AppMsgType, objectType ObjectType, r Range, perRequest int) ([]*proto.Msg, error) {
+	if r.Start < 1 || r.End < r.Start || r.End > 0xFFFF {
+		return nil, errors.Errorf("Invalid object range %d-%d", r.Start, r.End)
 	}
 
-	return out, err
+	resps := []*proto.Msg{}
+	for first := r.Start; first <= r.End; first += perRequest {
+		last := first + perRequest - 1
+		if last > r.End {
+			last = r.End
+		}
+		m := &proto.Msg{
+			Type: req,
+			Data: []byte{
+				byte(objectType),
+				byte(first >> 8),
+				byte(first),
+				byte(last >> 8),
+				byte(last),
+			},
+		}
+
+		resp, err := c.sendMessage(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Type != reply || len(resp.Data) < 1 {
+			return nil, replyError(m, resp, fmt.Sprintf("report %s status", objectType))
+		}
+		if resp.Data[0] != uint8(objectType) {
+			return nil, errors.Errorf("Wrong return typed '%d' for input type '%d'", resp.Data[0], objectType)
+		}
+		resps = append(resps, resp)
+	}
+	return resps, nil
 }
 
 // sendMessage sends an application data message to the controller and returns a response
-func (c *Client) sendMessage(m *proto.Msg) (*proto.Msg, error) {
-	err := c.conn.Write(m, time.Second*10)
+func (c *Client) sendMessage(ctx context.Context, m *proto.Msg) (*proto.Msg, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	conn, err := c.connection(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to write")
+		return nil, err
 	}
-	return c.conn.Read(time.Second * 20)
+	return sendOn(ctx, conn, m)
+}
+
+// sendOn sends an application data message on a specific connection and returns a response
+func sendOn(ctx context.Context, conn proto.Conn, m *proto.Msg) (*proto.Msg, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	return conn.Request(ctx, m)
+}
+
+// withDefaultTimeout applies defaultTimeout to a context without a deadline.
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
 }
 
 // unmarshalMessage unpacks an application data message into a struct
@@ -224,7 +499,25 @@ func unmarshalMessage(msg *proto.Msg, data interface{}) error {
 	return binary.Read(reader, binary.LittleEndian, data)
 }
 
-func parseKey(key string) (proto.StaticKey, error) {
+// unmarshalRecords unpacks consecutive fixed size records into a slice of structs
+func unmarshalRecords(records []byte, data interface{}) error {
+	return binary.Read(bytes.NewReader(records), binary.LittleEndian, data)
+}
+
+// unmarshalMessages unpacks each message into the struct returned by dest for its index
+func unmarshalMessages(msgs []*proto.Msg, dest func(i int) interface{}) error {
+	for i, msg := range msgs {
+		err := unmarshalMessage(msg, dest(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseKey converts a controller key written as hex digits, such as 00-11-22-33-44-55-66-77-88-99-AA-BB-CC-DD-EE-FF,
+// into a StaticKey.
+func ParseKey(key string) (proto.StaticKey, error) {
 	hexOnly := strings.Replace(key, "-", "", -1)
 	keyBytes, err := hex.DecodeString(hexOnly)
 	if err != nil {
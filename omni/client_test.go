@@ -0,0 +1,503 @@
+package omni_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/omnisim"
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/pkg/errors"
+)
+
+// newTestClient starts a simulator of inst and returns a Client connected to it. Both are closed by the
+// returned function.
+func newTestClient(t *testing.T, inst *omnisim.Installation, opts ...omni.Option) (*omnisim.Simulator, *omni.Client, func()) {
+	t.Helper()
+	sim, err := omnisim.ListenDemo(inst)
+	if err != nil {
+		t.Fatalf("Failed to start simulator: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := omni.NewClient(ctx, sim.Addr(), omnisim.DemoKey, opts...)
+	if err != nil {
+		sim.Close()
+		t.Fatalf("Failed to connect to simulator: %s", err)
+	}
+	return sim, c, func() {
+		c.Close()
+		sim.Close()
+	}
+}
+
+func celsius(c float64) omni.Temperature {
+	t, err := omni.TemperatureFromCelsius(c)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func name(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+func TestHandshake(t *testing.T) {
+	sim, err := omnisim.ListenDemo(omnisim.Demo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	ctx, cancel := testContext()
+	defer cancel()
+	_, err = omni.NewClient(ctx, sim.Addr(), "00-00-00-00-00-00-00-00-00-00-00-00-00-00-00-00")
+	if !errors.Is(err, proto.ErrBadKey) {
+		t.Errorf("Connecting with the wrong key returned %v, want ErrBadKey", err)
+	}
+
+	c, err := omni.NewClient(ctx, sim.Addr(), omnisim.DemoKey)
+	if err != nil {
+		t.Fatalf("Connecting with the right key failed: %s", err)
+	}
+	c.Close()
+}
+
+func TestSystemInformation(t *testing.T) {
+	_, c, done := newTestClient(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	info, err := c.GetSystemInformation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModelNumber != 30 || info.MajorVersion != 3 || info.MinorVersion != 14 {
+		t.Errorf("Unexpected system information %+v", info)
+	}
+
+	st, err := c.GetSystemStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Year != 26 || st.Month != 10 || st.Day != 18 || st.Battery != 200 {
+		t.Errorf("Unexpected system status %+v", st)
+	}
+
+	troubles, err := c.GetSystemTroubles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(troubles.Troubles) != 1 || troubles.Troubles[0] != omni.ACPower {
+		t.Errorf("Unexpected troubles %v", troubles.Troubles)
+	}
+
+	features, err := c.GetSystemFeatures(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features.Features) != 1 || features.Features[0] != omni.HAIHiFi {
+		t.Errorf("Unexpected features %v", features.Features)
+	}
+
+	formats, err := c.GetSystemFormats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formats.TempFormat != omni.Celsius || formats.TimeFormat != omni.TwentyFour || formats.DateFormat != omni.DDMM {
+		t.Errorf("Unexpected formats %+v", formats)
+	}
+
+	capacity, err := c.GetObjectTypeCapacity(ctx, omni.Unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := int(capacity.CapacityMSB)<<8 | int(capacity.CapacityLSB); n != 120 {
+		t.Errorf("Unit capacity is %d, want 120", n)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	_, c, done := newTestClient(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	zones, err := c.Zones(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 3 || name(zones[0].Name[:]) != "FRONT DOOR" || name(zones[2].Name[:]) != "GARAGE" {
+		t.Errorf("Unexpected zones %+v", zones)
+	}
+	if zones[0].LoopReading != 120 || zones[1].Status != 0x01 {
+		t.Errorf("Unexpected zone status in properties %+v", zones[:2])
+	}
+
+	units, err := c.Units(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 120 {
+		t.Errorf("Received %d units, want 120", len(units))
+	}
+
+	areas, err := c.Areas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(areas) != 1 || name(areas[0].Name[:]) != "HOUSE" || areas[0].Enabled == 0 {
+		t.Errorf("Unexpected areas %+v", areas)
+	}
+
+	thermostats, err := c.Thermostats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thermostats) != 1 || name(thermostats[0].Name[:]) != "DOWNSTAIRS" {
+		t.Fatalf("Unexpected thermostats %+v", thermostats)
+	}
+	if temp := thermostats[0].Temperature.Celsius(); temp != 21 {
+		t.Errorf("Thermostat temperature is %v, want 21", temp)
+	}
+
+	buttons, err := c.Buttons(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buttons) != 0 {
+		t.Errorf("Received %d buttons, want none", len(buttons))
+	}
+}
+
+func TestStatusRanges(t *testing.T) {
+	_, c, done := newTestClient(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	zones, err := c.ZoneStatus(ctx, omni.Range{Start: 2, End: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 2 || zones[0].NumberLSB != 2 || zones[0].Status != 0x01 || zones[1].NumberLSB != 3 {
+		t.Errorf("Unexpected zone status %+v", zones)
+	}
+
+	// 120 unit records do not fit in one reply
+	units, err := c.UnitStatus(ctx, omni.Range{Start: 1, End: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 120 {
+		t.Fatalf("Received %d unit records, want 120", len(units))
+	}
+	for i, u := range units {
+		n := int(u.NumberMSB)<<8 | int(u.NumberLSB)
+		if n != i+1 || int(u.TimeMSB)<<8|int(u.TimeLSB) != n {
+			t.Fatalf("Unit record %d is %+v", i, u)
+		}
+	}
+
+	thermostats, err := c.ExtendedThermostatStatus(ctx, omni.Range{Start: 1, End: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thermostats) != 1 || thermostats[0].Humidity != 45 || thermostats[0].OutdoorTemperature.Celsius() != 5 {
+		t.Errorf("Unexpected extended thermostat status %+v", thermostats)
+	}
+
+	_, err = c.ZoneStatus(ctx, omni.Range{Start: 3, End: 2})
+	if err == nil {
+		t.Error("Requesting an empty range succeeded")
+	}
+	// Objects which are not configured are reported with zero status
+	zones, err = c.ZoneStatus(ctx, omni.Range{Start: 3, End: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 2 || zones[1] != (omni.ZoneStatus{NumberLSB: 4}) {
+		t.Errorf("Unexpected status of unconfigured zone %+v", zones)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	sim, c, done := newTestClient(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	err := c.UnitLevel(ctx, 5, 40, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units, err := c.UnitStatus(ctx, omni.Range{Start: 5, End: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if units[0].State != 140 {
+		t.Errorf("Unit state is %d after setting 40%%, want 140", units[0].State)
+	}
+	// The timed level command takes at least two seconds
+	err = c.UnitLevel(ctx, 5, 60, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.ArmArea(ctx, 1, omni.Away, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Update(func(inst *omnisim.Installation) {
+		if inst.Areas[1].Mode != omni.Away {
+			t.Errorf("Area mode is %s after arming away", inst.Areas[1].Mode)
+		}
+	})
+
+	err = c.SetThermostatHeatSetpoint(ctx, 1, celsius(18))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thermostats, err := c.ThermostatStatus(ctx, omni.Range{Start: 1, End: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp := thermostats[0].HeatSetPoint.Celsius(); sp != 18 {
+		t.Errorf("Heat setpoint is %v, want 18", sp)
+	}
+
+	// The simulator refuses commands for objects which are not configured
+	err = c.UnitOn(ctx, 200, 0)
+	var cerr omni.CommandError
+	if !errors.Is(err, omni.ErrNegativeAck) || !errors.As(err, &cerr) || cerr.Param2 != 200 {
+		t.Errorf("Turning on a missing unit returned %v, want a CommandError", err)
+	}
+
+	// Arguments which do not fit the command are rejected before sending
+	for _, code := range []int{0, 100, 300, -1} {
+		if err := c.ArmArea(ctx, 1, omni.Away, code); err == nil {
+			t.Errorf("Arming with code number %d succeeded", code)
+		}
+	}
+	if err := c.BypassZone(ctx, -1, 1); err == nil {
+		t.Error("Bypassing zone -1 succeeded")
+	}
+	for _, unit := range []int{0, -1, 70000} {
+		if err := c.UnitOn(ctx, unit, 0); err == nil {
+			t.Errorf("Turning on unit %d succeeded", unit)
+		}
+	}
+	if err := c.SetThermostatMode(ctx, -1, omni.ThermostatHeat); err == nil {
+		t.Error("Setting the mode of thermostat -1 succeeded")
+	}
+	// Setpoints above 50°C and 100% select a user setting instead
+	if err := c.SetThermostatCoolSetpoint(ctx, 1, celsius(61)); err == nil {
+		t.Error("Setting a cool setpoint of 61°C succeeded")
+	}
+	if err := c.SetThermostatDehumidifySetpoint(ctx, 1, 157); err == nil {
+		t.Error("Setting a dehumidify setpoint of 157 succeeded")
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	sim, c, done := newTestClient(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	events, err := c.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() omni.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for an event")
+		}
+		return nil
+	}
+
+	sim.SendEvents(omni.UserMacroButtonEvent{Button: 7}.Code(), omni.TroubleEvent{Trouble: omni.ACPower, Cleared: true}.Code())
+	if e, ok := next().(omni.UserMacroButtonEvent); !ok || e.Button != 7 {
+		t.Errorf("Received %#v, want button 7", e)
+	}
+	if e, ok := next().(omni.TroubleEvent); !ok || e.Trouble != omni.ACPower || !e.Cleared {
+		t.Errorf("Received %#v, want AC power restored", e)
+	}
+
+	// Commands cause status notifications
+	err = c.UnitOn(ctx, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := next().(omni.UnitStatusEvent); !ok || e.NumberLSB != 3 || e.State != 1 {
+		t.Errorf("Received %#v, want unit 3 on", e)
+	}
+
+	sim.Update(func(inst *omnisim.Installation) {
+		inst.Zones[1].Status = 0x01
+	})
+	sim.NotifyStatus(omni.Zone, 1)
+	if e, ok := next().(omni.ZoneStatusEvent); !ok || e.NumberLSB != 1 || e.Status != 0x01 {
+		t.Errorf("Received %#v, want zone 1 not ready", e)
+	}
+}
+
+// stallConn stops returning data once stalled, as a client does when it stops reading from its connection.
+type stallConn struct {
+	net.Conn
+	stall  chan struct{}
+	closed chan struct{}
+}
+
+func (c *stallConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.stall:
+		<-c.closed
+		return 0, io.EOF
+	default:
+		return c.Conn.Read(b)
+	}
+}
+
+func (c *stallConn) Close() error {
+	close(c.closed)
+	return c.Conn.Close()
+}
+
+// TestStalledClient checks that a client which stops reading does not hold up other sessions.
+func TestStalledClient(t *testing.T) {
+	sim, c, done := newTestClient(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	// A second session enables notifications and then stops reading
+	key, _ := omni.ParseKey(omnisim.DemoKey)
+	nconn, err := net.Dial("tcp", sim.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := &stallConn{Conn: nconn, stall: make(chan struct{}), closed: make(chan struct{})}
+	stalled, err := proto.NewConnectionFromNetConn(ctx, sc, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stalled.Request(ctx, &proto.Msg{Type: proto.MsgEnableNotifications, Data: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(sc.stall)
+
+	// Send enough events to fill the socket buffers
+	codes := make([]uint16, 120)
+	flooded := make(chan struct{})
+	go func() {
+		defer close(flooded)
+		for i := 0; i < 20000; i++ {
+			sim.SendEvents(codes...)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		reqCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		_, err = c.GetSystemStatus(reqCtx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Request failed while another client was stalled: %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	stalled.Close()
+	<-flooded
+}
+
+// closeDialer dials TCP connections and records each connection it returns.
+type closeDialer struct {
+	mu    sync.Mutex
+	conns []*closeConn
+}
+
+// closeConn is a connection which reports when it is closed.
+type closeConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func (d *closeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	nconn, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &closeConn{Conn: nconn, closed: make(chan struct{})}
+	d.mu.Lock()
+	d.conns = append(d.conns, c)
+	d.mu.Unlock()
+	return c, nil
+}
+
+// TestReconnect terminates the session and checks that the client reports the lost connection, closes
+// it and establishes a new session.
+func TestReconnect(t *testing.T) {
+	states := make(chan omni.ConnState, 10)
+	dialer := &closeDialer{}
+	sim, c, done := newTestClient(t, omnisim.Demo(),
+		omni.WithReconnect(10*time.Millisecond, 100*time.Millisecond),
+		omni.WithStateFunc(func(state omni.ConnState, err error) { states <- state }),
+		omni.WithDialer(dialer))
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	for _, want := range []omni.ConnState{omni.Connecting, omni.Secure} {
+		if state := <-states; state != want {
+			t.Fatalf("State changed to %s while connecting, want %s", state, want)
+		}
+	}
+	sim.Terminate()
+	for _, want := range []omni.ConnState{omni.Lost, omni.Connecting, omni.Secure} {
+		select {
+		case state := <-states:
+			if state != want {
+				t.Fatalf("State changed to %s after the session was terminated, want %s", state, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for state %s", want)
+		}
+	}
+
+	dialer.mu.Lock()
+	conns := dialer.conns
+	dialer.mu.Unlock()
+	if len(conns) != 2 {
+		t.Fatalf("Dialed %d connections, want 2", len(conns))
+	}
+	select {
+	case <-conns[0].closed:
+	case <-ctx.Done():
+		t.Error("Terminated connection was not closed")
+	}
+	_, err := c.GetSystemStatus(ctx)
+	if err != nil {
+		t.Errorf("Request after reconnecting failed: %s", err)
+	}
+}
@@ -0,0 +1,371 @@
+package omni
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/pkg/errors"
+)
+
+//go:generate stringer -type=SecurityMode
+//go:generate stringer -type=ThermostatMode
+//go:generate stringer -type=FanMode
+
+// Command is a controller command sent with the Command message.
+type Command uint8
+
+const (
+	CmdUnitOff               Command = 0
+	CmdUnitOn                Command = 1
+	CmdAreaAllOff            Command = 2
+	CmdAreaAllOn             Command = 3
+	CmdBypassZone            Command = 4
+	CmdRestoreZone           Command = 5
+	CmdRestoreAllZones       Command = 6
+	CmdExecuteButton         Command = 7
+	CmdSetEnergyCost         Command = 8
+	CmdUnitLevel             Command = 9
+	CmdDecrementCounter      Command = 10
+	CmdIncrementCounter      Command = 11
+	CmdSetCounter            Command = 12
+	CmdUnitRamp              Command = 13
+	CmdComposeScene          Command = 14
+	CmdUPBRequestStatus      Command = 15
+	CmdUnitDim               Command = 16 // Plus the number of steps, 1-9
+	CmdUnitBrighten          Command = 32 // Plus the number of steps, 1-9
+	CmdSecurityMode          Command = 48 // Plus the SecurityMode
+	CmdEnergySaverOff        Command = 64
+	CmdEnergySaverOn         Command = 65
+	CmdSetHeatSetpoint       Command = 66
+	CmdSetCoolSetpoint       Command = 67
+	CmdSetThermostatMode     Command = 68
+	CmdSetFanMode            Command = 69
+	CmdSetHold               Command = 70
+	CmdAdjustHeatSetpoint    Command = 71
+	CmdAdjustCoolSetpoint    Command = 72
+	CmdSetHumidifySetpoint   Command = 73
+	CmdSetDehumidifySetpoint Command = 74
+	CmdShowMessage           Command = 80
+	CmdLogMessage            Command = 81
+	CmdClearMessage          Command = 82
+	CmdSayMessage            Command = 83
+	CmdUnitLevelTimed        Command = 101
+	CmdLockDoor              Command = 105
+	CmdUnlockDoor            Command = 106
+	CmdAudioZone             Command = 112
+	CmdAudioVolume           Command = 113
+	CmdAudioSource           Command = 114
+	CmdAudioKey              Command = 115
+)
+
+// SecurityMode is the arming mode of an area on Omni series controllers.
+type SecurityMode uint8
+
+const (
+	Disarmed SecurityMode = iota
+	Day
+	Night
+	Away
+	Vacation
+	DayInstant
+	NightDelayed
+)
+
+type ThermostatMode uint8
+
+const (
+	ThermostatOff ThermostatMode = iota
+	ThermostatHeat
+	ThermostatCool
+	ThermostatAuto
+	ThermostatEmergencyHeat
+)
+
+type FanMode uint8
+
+const (
+	FanAuto FanMode = iota
+	FanOn
+	FanCycle
+)
+
+// The highest setpoints of the thermostat commands, 50 degC and 100% relative humidity. Higher values
+// select a user setting.
+const (
+	maxTemperatureSetpoint Temperature = 180
+	maxHumiditySetpoint    Humidity    = 156
+)
+
+// CommandError is returned when the controller responds to a command with a negative acknowledge.
+type CommandError struct {
+	Command Command
+	Param1  uint8
+	Param2  uint16
+}
+
+func (ce CommandError) Error() string {
+	return fmt.Sprintf("Controller refused command %d (%d, %d)", ce.Command, ce.Param1, ce.Param2)
+}
+
+// Is reports whether target is ErrNegativeAck.
+func (ce CommandError) Is(target error) bool {
+	return target == ErrNegativeAck
+}
+
+// Command sends a controller command and waits for the controller to acknowledge it.
+func (c *Client) Command(ctx context.Context, cmd Command, param1 uint8, param2 uint16) error {
+	data := []byte{byte(cmd), param1, 0, 0}
+	binary.BigEndian.PutUint16(data[2:], param2)
+	m := &proto.Msg{
+		Type: proto.MsgCommand,
+		Data: data,
+	}
+
+	resp, err := c.sendMessage(ctx, m)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to send command %d", cmd)
+	}
+	switch resp.Type {
+	case proto.MsgAck:
+		return nil
+	case proto.MsgNak:
+		return CommandError{Command: cmd, Param1: param1, Param2: param2}
+	}
+	return proto.UnexpectedReplyError{Request: m.Type, Reply: resp.Type}
+}
+
+// UnitOn turns a unit on. A non-zero duration turns the unit back off once it elapses.
+func (c *Client) UnitOn(ctx context.Context, unit int, d time.Duration) error {
+	err := checkNumber("Unit", unit, 1)
+	if err != nil {
+		return err
+	}
+	p1, err := encodeDuration(d)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, CmdUnitOn, p1, uint16(unit))
+}
+
+// UnitOff turns a unit off. A non-zero duration turns the unit back on once it elapses.
+func (c *Client) UnitOff(ctx context.Context, unit int, d time.Duration) error {
+	err := checkNumber("Unit", unit, 1)
+	if err != nil {
+		return err
+	}
+	p1, err := encodeDuration(d)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, CmdUnitOff, p1, uint16(unit))
+}
+
+// UnitLevel sets the lighting level of a unit to a percentage. A non-zero duration restores
+// the previous level once it elapses, and is at least two seconds.
+func (c *Client) UnitLevel(ctx context.Context, unit int, percent int, d time.Duration) error {
+	if percent < 0 || percent > 100 {
+		return errors.Errorf("Level %d must be between 0 and 100", percent)
+	}
+	if d == 0 {
+		err := checkNumber("Unit", unit, 1)
+		if err != nil {
+			return err
+		}
+		return c.Command(ctx, CmdUnitLevel, uint8(percent), uint16(unit))
+	}
+	// The timed level command takes 2-99 seconds
+	if d < 2*time.Second {
+		d = 2 * time.Second
+	}
+	p1, err := encodeDuration(d)
+	if err != nil {
+		return err
+	}
+	p2, err := packUnitLevel(unit, percent)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, CmdUnitLevelTimed, p1, p2)
+}
+
+// UnitDim dims a unit by 1-9 steps, for the given duration if non-zero.
+func (c *Client) UnitDim(ctx context.Context, unit int, steps int, d time.Duration) error {
+	return c.stepUnit(ctx, CmdUnitDim, unit, steps, d)
+}
+
+// UnitBrighten brightens a unit by 1-9 steps, for the given duration if non-zero.
+func (c *Client) UnitBrighten(ctx context.Context, unit int, steps int, d time.Duration) error {
+	return c.stepUnit(ctx, CmdUnitBrighten, unit, steps, d)
+}
+
+func (c *Client) stepUnit(ctx context.Context, cmd Command, unit int, steps int, d time.Duration) error {
+	if steps < 1 || steps > 9 {
+		return errors.Errorf("Steps %d must be between 1 and 9", steps)
+	}
+	err := checkNumber("Unit", unit, 1)
+	if err != nil {
+		return err
+	}
+	p1, err := encodeDuration(d)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, cmd+Command(steps), p1, uint16(unit))
+}
+
+// ExecuteButton runs a macro button.
+func (c *Client) ExecuteButton(ctx context.Context, button int) error {
+	return c.Command(ctx, CmdExecuteButton, 0, uint16(button))
+}
+
+// SetThermostatHeatSetpoint sets the heat setpoint of a thermostat or the low setpoint of a temperature sensor.
+// Zero means all thermostats.
+func (c *Client) SetThermostatHeatSetpoint(ctx context.Context, thermostat int, temp Temperature) error {
+	if temp > maxTemperatureSetpoint {
+		return errors.Errorf("Heat setpoint %s is above %s", temp, maxTemperatureSetpoint)
+	}
+	return c.thermostatCommand(ctx, CmdSetHeatSetpoint, uint8(temp), thermostat)
+}
+
+// SetThermostatCoolSetpoint sets the cool setpoint of a thermostat or the high setpoint of a temperature sensor.
+// Zero means all thermostats.
+func (c *Client) SetThermostatCoolSetpoint(ctx context.Context, thermostat int, temp Temperature) error {
+	if temp > maxTemperatureSetpoint {
+		return errors.Errorf("Cool setpoint %s is above %s", temp, maxTemperatureSetpoint)
+	}
+	return c.thermostatCommand(ctx, CmdSetCoolSetpoint, uint8(temp), thermostat)
+}
+
+// SetThermostatHumidifySetpoint sets the humidify setpoint of a thermostat. Zero means all thermostats.
+func (c *Client) SetThermostatHumidifySetpoint(ctx context.Context, thermostat int, h Humidity) error {
+	if h > maxHumiditySetpoint {
+		return errors.Errorf("Humidify setpoint %d is above %d", h, maxHumiditySetpoint)
+	}
+	return c.thermostatCommand(ctx, CmdSetHumidifySetpoint, uint8(h), thermostat)
+}
+
+// SetThermostatDehumidifySetpoint sets the dehumidify setpoint of a thermostat. Zero means all thermostats.
+func (c *Client) SetThermostatDehumidifySetpoint(ctx context.Context, thermostat int, h Humidity) error {
+	if h > maxHumiditySetpoint {
+		return errors.Errorf("Dehumidify setpoint %d is above %d", h, maxHumiditySetpoint)
+	}
+	return c.thermostatCommand(ctx, CmdSetDehumidifySetpoint, uint8(h), thermostat)
+}
+
+// SetThermostatMode sets the system mode of a thermostat. Zero means all thermostats.
+func (c *Client) SetThermostatMode(ctx context.Context, thermostat int, mode ThermostatMode) error {
+	return c.thermostatCommand(ctx, CmdSetThermostatMode, uint8(mode), thermostat)
+}
+
+// SetFanMode sets the fan mode of a thermostat. Zero means all thermostats.
+func (c *Client) SetFanMode(ctx context.Context, thermostat int, mode FanMode) error {
+	return c.thermostatCommand(ctx, CmdSetFanMode, uint8(mode), thermostat)
+}
+
+// SetHold places a thermostat in or out of hold. Zero means all thermostats.
+func (c *Client) SetHold(ctx context.Context, thermostat int, hold bool) error {
+	var p1 uint8
+	if hold {
+		p1 = 255
+	}
+	return c.thermostatCommand(ctx, CmdSetHold, p1, thermostat)
+}
+
+func (c *Client) thermostatCommand(ctx context.Context, cmd Command, param1 uint8, thermostat int) error {
+	err := checkNumber("Thermostat", thermostat, 0)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, cmd, param1, uint16(thermostat))
+}
+
+// ArmArea arms an area in the given mode. The code is the user code number, not the four digit code. Area zero means all areas.
+func (c *Client) ArmArea(ctx context.Context, area int, mode SecurityMode, code int) error {
+	if mode > NightDelayed {
+		return errors.Errorf("Invalid security mode %d", mode)
+	}
+	err := checkCommandArgs("Area", area, code)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, CmdSecurityMode+Command(mode), uint8(code), uint16(area))
+}
+
+// Disarm disarms an area. The code is the user code number, not the four digit code. Area zero means all areas.
+func (c *Client) Disarm(ctx context.Context, area int, code int) error {
+	return c.ArmArea(ctx, area, Disarmed, code)
+}
+
+// BypassZone bypasses a zone. The code is the user code number, not the four digit code.
+func (c *Client) BypassZone(ctx context.Context, zone int, code int) error {
+	err := checkCommandArgs("Zone", zone, code)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, CmdBypassZone, uint8(code), uint16(zone))
+}
+
+// RestoreZone restores a bypassed zone. The code is the user code number, not the four digit code.
+func (c *Client) RestoreZone(ctx context.Context, zone int, code int) error {
+	err := checkCommandArgs("Zone", zone, code)
+	if err != nil {
+		return err
+	}
+	return c.Command(ctx, CmdRestoreZone, uint8(code), uint16(zone))
+}
+
+// checkCommandArgs checks the object number and user code number of a security command. Codes are
+// numbered 1-99, and CodeDuress is accepted as the controller reports it for the duress code.
+func checkCommandArgs(object string, number int, code int) error {
+	err := checkNumber(object, number, 0)
+	if err != nil {
+		return err
+	}
+	if (code < 1 || code > 99) && code != CodeDuress {
+		return errors.Errorf("Code number %d must be between 1 and 99", code)
+	}
+	return nil
+}
+
+// checkNumber checks that an object number fits the second command parameter. The lowest valid
+// number is min, which is zero for commands where zero means all objects.
+func checkNumber(object string, number int, min int) error {
+	if number < min || number > math.MaxUint16 {
+		return errors.Errorf("%s %d must be between %d and %d", object, number, min, math.MaxUint16)
+	}
+	return nil
+}
+
+// encodeDuration converts a duration into the command parameter format where
+// 1-99 is seconds, 101-199 is 1-99 minutes and 201-218 is 1-18 hours. Durations are rounded up.
+func encodeDuration(d time.Duration) (uint8, error) {
+	switch {
+	case d < 0:
+		return 0, errors.Errorf("Invalid negative duration %s", d)
+	case d == 0:
+		return 0, nil
+	case d <= 99*time.Second:
+		return uint8(ceilDiv(d, time.Second)), nil
+	case d <= 99*time.Minute:
+		return uint8(100 + ceilDiv(d, time.Minute)), nil
+	case d <= 18*time.Hour:
+		return uint8(200 + ceilDiv(d, time.Hour)), nil
+	}
+	return 0, errors.Errorf("Duration %s is longer than 18 hours", d)
+}
+
+func ceilDiv(d, unit time.Duration) int64 {
+	return int64((d + unit - 1) / unit)
+}
+
+// packUnitLevel stores a unit number in the low 9 bits and a level in the high 7 bits as used by the extended unit commands.
+func packUnitLevel(unit int, percent int) (uint16, error) {
+	if unit < 1 || unit > 511 {
+		return 0, errors.Errorf("Unit %d must be between 1 and 511", unit)
+	}
+	return uint16(percent)<<9 | uint16(unit), nil
+}
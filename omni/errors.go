@@ -0,0 +1,37 @@
+package omni
+
+import (
+	"fmt"
+
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/pkg/errors"
+)
+
+// ErrNegativeAck is matched by errors returned when the controller refuses a request with a negative
+// acknowledge, including NakError and CommandError.
+var ErrNegativeAck = errors.New("Controller refused the request")
+
+// NakError is returned when the controller refuses a request with a negative acknowledge. The controller
+// does not say why, so Reason describes what was refused.
+type NakError struct {
+	Request proto.AppMsgType
+	Reason  string
+}
+
+func (e NakError) Error() string {
+	return fmt.Sprintf("Controller refused to %s", e.Reason)
+}
+
+// Is reports whether target is ErrNegativeAck.
+func (e NakError) Is(target error) bool {
+	return target == ErrNegativeAck
+}
+
+// replyError returns the error for a reply of the wrong type to req. Negative acknowledges are reported
+// as a NakError with the reason, which completes "Controller refused to".
+func replyError(req, resp *proto.Msg, reason string) error {
+	if resp.Type == proto.MsgNak {
+		return NakError{Request: req.Type, Reason: reason}
+	}
+	return proto.UnexpectedReplyError{Request: req.Type, Reply: resp.Type}
+}
@@ -0,0 +1,285 @@
+package omni
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/pkg/errors"
+)
+
+//go:generate stringer -type=LogEventType
+//go:generate stringer -type=AlarmType
+
+// LogEventType is the kind of event recorded in the controller's event log.
+type LogEventType uint8
+
+const (
+	LogZoneBypassed       LogEventType = 4
+	LogZoneRestored       LogEventType = 5
+	LogAllZonesRestored   LogEventType = 6
+	LogDisarmed           LogEventType = 48 // The armed events follow in SecurityMode order
+	LogArmedDay           LogEventType = 49
+	LogArmedNight         LogEventType = 50
+	LogArmedAway          LogEventType = 51
+	LogArmedVacation      LogEventType = 52
+	LogArmedDayInstant    LogEventType = 53
+	LogArmedNightDelayed  LogEventType = 54
+	LogZoneTripped        LogEventType = 128
+	LogZoneTrouble        LogEventType = 129
+	LogRemotePhoneAccess  LogEventType = 130
+	LogRemotePhoneLockout LogEventType = 131
+	LogZoneAutoBypassed   LogEventType = 132
+	LogZoneTroubleCleared LogEventType = 133
+	LogPCAccess           LogEventType = 134
+	LogAlarmActivated     LogEventType = 135
+	LogAlarmReset         LogEventType = 136
+	LogSystemReset        LogEventType = 137
+	LogMessageLogged      LogEventType = 138
+	LogZoneShutDown       LogEventType = 139
+	LogAccessGranted      LogEventType = 140
+	LogAccessDenied       LogEventType = 141
+)
+
+// AlarmType is the kind of alarm in alarm activated and reset log entries.
+type AlarmType uint8
+
+const (
+	BurglaryAlarm AlarmType = 1 + iota
+	FireAlarm
+	GasAlarm
+	AuxiliaryAlarm
+	FreezeAlarm
+	WaterAlarm
+	DuressAlarm
+	TemperatureAlarm
+)
+
+// Security codes reported in log entries in place of a user code number.
+const (
+	CodeDuress     = 251
+	CodeKeyswitch  = 252
+	CodeQuickArm   = 253
+	CodePCAccess   = 254
+	CodeProgrammed = 255
+)
+
+// LogEntry is one record of the controller's event log. Zero is used for User, Area, Zone and Alarm
+// when they do not apply to the event type.
+type LogEntry struct {
+	Number    int  // Event number, higher numbers are more recent until the counter rolls over to 1
+	TimeValid bool // False if the controller clock was not set when the event occurred
+	Month     time.Month
+	Day       int
+	Hour      int
+	Minute    int
+	Type      LogEventType
+	User      int // User code number, or one of the Code constants
+	Area      int // Zero with area events also means all areas
+	Zone      int
+	Alarm     AlarmType
+	Param1    uint8
+	Param2    uint16
+}
+
+// eventLogData matches the byte layout of the event log data message.
+type eventLogData struct {
+	NumberMSB uint8
+	NumberLSB uint8
+	TimeValid uint8
+	Month     uint8
+	Day       uint8
+	Hour      uint8
+	Minute    uint8
+	Type      uint8
+	Param1    uint8
+	Param2MSB uint8
+	Param2LSB uint8
+}
+
+// maxLogEntries bounds the records read from the event log, which cannot hold more events than
+// there are event numbers.
+const maxLogEntries = 65535
+
+// EventLog returns every record in the controller's event log, oldest first.
+func (c *Client) EventLog(ctx context.Context) ([]LogEntry, error) {
+	entries := []LogEntry{}
+	seen := map[int]bool{}
+	// Event number zero with a direction of 1 returns the oldest event
+	number := 0
+	for len(entries) < maxLogEntries {
+		m := &proto.Msg{
+			Type: proto.MsgReadEventRecord,
+			Data: []byte{byte(number >> 8), byte(number), 1},
+		}
+		resp, err := c.sendMessage(ctx, m)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read event log")
+		}
+		if resp.Type == proto.MsgEndOfData {
+			break
+		}
+		if resp.Type != proto.MsgEventLogData {
+			return nil, replyError(m, resp, "read event log")
+		}
+		data := eventLogData{}
+		err = unmarshalMessage(resp, &data)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to unmarshal event log data")
+		}
+		entry := decodeLogEntry(data)
+		if entry.Number == number {
+			return nil, errors.Errorf("Event log did not advance past event %d", number)
+		}
+		if seen[entry.Number] {
+			// Wrapped around to an event already read
+			break
+		}
+		seen[entry.Number] = true
+		entries = append(entries, entry)
+		number = entry.Number
+	}
+	return entries, nil
+}
+
+func decodeLogEntry(d eventLogData) LogEntry {
+	e := LogEntry{
+		Number:    int(d.NumberMSB)<<8 | int(d.NumberLSB),
+		TimeValid: d.TimeValid != 0,
+		Month:     time.Month(d.Month),
+		Day:       int(d.Day),
+		Hour:      int(d.Hour),
+		Minute:    int(d.Minute),
+		Type:      LogEventType(d.Type),
+		Param1:    d.Param1,
+		Param2:    uint16(d.Param2MSB)<<8 | uint16(d.Param2LSB),
+	}
+	p1, p2 := int(e.Param1), int(e.Param2)
+	switch e.Type {
+	case LogZoneBypassed, LogZoneRestored:
+		e.User, e.Zone = p1, p2
+	case LogAllZonesRestored:
+		e.User, e.Area = p1, p2
+	case LogDisarmed, LogArmedDay, LogArmedNight, LogArmedAway, LogArmedVacation, LogArmedDayInstant, LogArmedNightDelayed:
+		e.User, e.Area = p1, p2
+	case LogZoneTripped, LogZoneTrouble, LogZoneAutoBypassed, LogZoneTroubleCleared, LogZoneShutDown:
+		e.Zone = p2
+	case LogRemotePhoneAccess, LogPCAccess:
+		e.User = p1
+	case LogAlarmActivated, LogAlarmReset:
+		e.Alarm, e.Area = AlarmType(p1), p2
+	case LogAccessGranted, LogAccessDenied:
+		e.User = p1
+	}
+	return e
+}
+
+// Time returns when the event occurred. The event log does not record the year, so the most recent
+// year which places the event at or before now is used. The result is meaningless unless TimeValid is set.
+func (e LogEntry) Time(now time.Time) time.Time {
+	t := time.Date(now.Year(), e.Month, e.Day, e.Hour, e.Minute, 0, 0, now.Location())
+	if t.After(now) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// Format returns the time and description of the event using the controller's date and time formats.
+func (e LogEntry) Format(f SystemFormats) string {
+	return fmt.Sprintf("%s %s", e.formatTime(f), e)
+}
+
+func (e LogEntry) formatTime(f SystemFormats) string {
+	if !e.TimeValid {
+		if f.TimeFormat == TwentyFour {
+			return "--/-- --:--"
+		}
+		return "--/-- --:-- --"
+	}
+
+	date := fmt.Sprintf("%02d/%02d", int(e.Month), e.Day)
+	if f.DateFormat == DDMM {
+		date = fmt.Sprintf("%02d/%02d", e.Day, int(e.Month))
+	}
+	if f.TimeFormat == TwentyFour {
+		return fmt.Sprintf("%s %02d:%02d", date, e.Hour, e.Minute)
+	}
+	hour, ampm := e.Hour%12, "AM"
+	if hour == 0 {
+		hour = 12
+	}
+	if e.Hour >= 12 {
+		ampm = "PM"
+	}
+	return fmt.Sprintf("%s %2d:%02d %s", date, hour, e.Minute, ampm)
+}
+
+// String describes the event.
+func (e LogEntry) String() string {
+	switch e.Type {
+	case LogZoneBypassed:
+		return fmt.Sprintf("Zone %d bypassed by %s", e.Zone, userName(e.User))
+	case LogZoneRestored:
+		return fmt.Sprintf("Zone %d restored by %s", e.Zone, userName(e.User))
+	case LogAllZonesRestored:
+		return fmt.Sprintf("All zones in %s restored by %s", areaName(e.Area), userName(e.User))
+	case LogDisarmed:
+		return fmt.Sprintf("%s disarmed by %s", areaName(e.Area), userName(e.User))
+	case LogArmedDay, LogArmedNight, LogArmedAway, LogArmedVacation, LogArmedDayInstant, LogArmedNightDelayed:
+		mode := SecurityMode(e.Type - LogDisarmed)
+		return fmt.Sprintf("%s armed %s by %s", areaName(e.Area), mode, userName(e.User))
+	case LogZoneTripped:
+		return fmt.Sprintf("Zone %d tripped", e.Zone)
+	case LogZoneTrouble:
+		return fmt.Sprintf("Zone %d trouble", e.Zone)
+	case LogRemotePhoneAccess:
+		return fmt.Sprintf("Remote phone access by %s", userName(e.User))
+	case LogRemotePhoneLockout:
+		return "Remote phone lockout"
+	case LogZoneAutoBypassed:
+		return fmt.Sprintf("Zone %d auto bypassed", e.Zone)
+	case LogZoneTroubleCleared:
+		return fmt.Sprintf("Zone %d trouble cleared", e.Zone)
+	case LogPCAccess:
+		return fmt.Sprintf("PC access by %s", userName(e.User))
+	case LogAlarmActivated:
+		return fmt.Sprintf("%s activated in %s", e.Alarm, areaName(e.Area))
+	case LogAlarmReset:
+		return fmt.Sprintf("%s reset in %s", e.Alarm, areaName(e.Area))
+	case LogSystemReset:
+		return "System reset"
+	case LogMessageLogged:
+		return fmt.Sprintf("Message %d logged", e.Param2)
+	case LogZoneShutDown:
+		return fmt.Sprintf("Zone %d shut down", e.Zone)
+	case LogAccessGranted:
+		return fmt.Sprintf("Access granted to %s at reader %d", userName(e.User), e.Param2)
+	case LogAccessDenied:
+		return fmt.Sprintf("Access denied to %s at reader %d", userName(e.User), e.Param2)
+	}
+	return fmt.Sprintf("%s (%d, %d)", e.Type, e.Param1, e.Param2)
+}
+
+func userName(code int) string {
+	switch code {
+	case CodeDuress:
+		return "duress code"
+	case CodeKeyswitch:
+		return "keyswitch"
+	case CodeQuickArm:
+		return "quick arm"
+	case CodePCAccess:
+		return "PC Access"
+	case CodeProgrammed:
+		return "programmed code"
+	}
+	return fmt.Sprintf("user %d", code)
+}
+
+func areaName(area int) string {
+	if area == 0 {
+		return "All areas"
+	}
+	return fmt.Sprintf("Area %d", area)
+}
@@ -0,0 +1,96 @@
+package omni_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/omnisim"
+	"github.com/leelynne/omnilink/omni/proto"
+)
+
+func TestEventLog(t *testing.T) {
+	inst := omnisim.Demo()
+	inst.EventLog = []omni.LogEntry{
+		{Number: 65534, TimeValid: true, Month: time.October, Day: 17, Hour: 22, Minute: 5, Type: omni.LogArmedNight, Param1: 1, Param2: 1},
+		{Number: 65535, TimeValid: true, Month: time.October, Day: 18, Hour: 7, Minute: 0, Type: omni.LogDisarmed, Param1: 1, Param2: 1},
+		{Number: 1, Month: time.October, Day: 18, Hour: 8, Minute: 12, Type: omni.LogZoneTripped, Param2: 2},
+	}
+	_, c, done := newTestClient(t, inst)
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	entries, err := c.EventLog(ctx)
+	if err != nil {
+		t.Fatalf("EventLog failed: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("EventLog returned %d entries, want 3", len(entries))
+	}
+	for i, want := range []struct {
+		number, user, area, zone int
+		typ                      omni.LogEventType
+	}{
+		{65534, 1, 1, 0, omni.LogArmedNight},
+		{65535, 1, 1, 0, omni.LogDisarmed},
+		{1, 0, 0, 2, omni.LogZoneTripped},
+	} {
+		e := entries[i]
+		if e.Number != want.number || e.User != want.user || e.Area != want.area || e.Zone != want.zone || e.Type != want.typ {
+			t.Errorf("Entry %d is %+v", i, e)
+		}
+	}
+	if entries[2].TimeValid || !entries[1].TimeValid || entries[1].Hour != 7 {
+		t.Errorf("Unexpected entry times %+v", entries)
+	}
+}
+
+// TestEventLogLoop replays controllers which answer a read event record request with an event already
+// read. EventLog must stop rather than reading forever.
+func TestEventLogLoop(t *testing.T) {
+	const (
+		event5 = "0005010a12091e30010001"
+		event6 = "0006010a12091f31010001"
+	)
+	tests := []struct {
+		name      string
+		recording string
+		entries   int // Entries returned, or -1 for an error
+	}{
+		{
+			"wrapped",
+			"2026-10-18T09:30:00Z send 1 24 000001\n2026-10-18T09:30:00Z recv 1 25 " + event5 + "\n" +
+				"2026-10-18T09:30:00Z send 2 24 000501\n2026-10-18T09:30:00Z recv 2 25 " + event6 + "\n" +
+				"2026-10-18T09:30:00Z send 3 24 000601\n2026-10-18T09:30:00Z recv 3 25 " + event5 + "\n",
+			2,
+		},
+		{
+			"repeated",
+			"2026-10-18T09:30:00Z send 1 24 000001\n2026-10-18T09:30:00Z recv 1 25 " + event5 + "\n" +
+				"2026-10-18T09:30:00Z send 2 24 000501\n2026-10-18T09:30:00Z recv 2 25 " + event5 + "\n",
+			-1,
+		},
+	}
+	for _, test := range tests {
+		conn, err := proto.NewReplayConn(strings.NewReader(test.recording))
+		if err != nil {
+			t.Fatalf("NewReplayConn failed: %s", err)
+		}
+		c, err := omni.NewClientFromConn(conn)
+		if err != nil {
+			t.Fatalf("NewClientFromConn failed: %s", err)
+		}
+		ctx, cancel := testContext()
+		entries, err := c.EventLog(ctx)
+		cancel()
+		c.Close()
+		switch {
+		case test.entries < 0 && err == nil:
+			t.Errorf("EventLog of %s log returned %+v, want an error", test.name, entries)
+		case test.entries >= 0 && (err != nil || len(entries) != test.entries):
+			t.Errorf("EventLog of %s log returned %d entries and %v, want %d entries", test.name, len(entries), err, test.entries)
+		}
+	}
+}
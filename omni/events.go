@@ -0,0 +1,455 @@
+package omni
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/pkg/errors"
+)
+
+//go:generate stringer -type=PhoneLineState
+//go:generate stringer -type=EnergyCost
+//go:generate stringer -type=UPBLinkCommand
+
+// Event is a notification broadcast by the controller, either a SystemEvent or an object status change.
+type Event interface {
+	event()
+}
+
+// SystemEvent is an event sent in a System Events message.
+type SystemEvent interface {
+	Event
+	// Code is the raw 16-bit event word sent by the controller.
+	Code() uint16
+}
+
+type PhoneLineState uint8
+
+const (
+	PhoneLineDead PhoneLineState = iota
+	PhoneLineRing
+	PhoneLineOffHook
+	PhoneLineOnHook
+)
+
+type EnergyCost uint8
+
+const (
+	EnergyCostLow EnergyCost = iota
+	EnergyCostMid
+	EnergyCostHigh
+	EnergyCostCritical
+)
+
+type UPBLinkCommand uint8
+
+const (
+	UPBLinkOff UPBLinkCommand = iota
+	UPBLinkOn
+	UPBLinkSet
+	UPBLinkFadeStop
+)
+
+// UserMacroButtonEvent is sent when a user activates a macro button.
+type UserMacroButtonEvent struct {
+	Button int
+}
+
+// ProLinkMessageEvent is sent when a Pro-Link message is received.
+type ProLinkMessageEvent struct {
+	Message int
+}
+
+// CentraLiteSwitchEvent is sent when a CentraLite switch is pressed.
+type CentraLiteSwitchEvent struct {
+	Switch int
+}
+
+// ComposeCodeEvent is sent when a Lightolier Compose code is received.
+type ComposeCodeEvent struct {
+	State     int  // 0 = off, 1 = on, 2-13 = scene A-L
+	HouseCode byte // A-P
+	Unit      int  // 1-16
+}
+
+// X10CodeEvent is sent when an X-10 code is received.
+type X10CodeEvent struct {
+	On        bool
+	AllUnits  bool
+	HouseCode byte // A-P
+	Unit      int  // 1-16
+}
+
+// SwitchPressEvent is sent when an ALC, UPB, RadioRA or Starlite switch is pressed.
+type SwitchPressEvent struct {
+	Switch int // 0 = off, 1 = on, 2-11 = switch 1-10
+	Unit   int
+}
+
+// UPBLinkEvent is sent when a UPB link command is received.
+type UPBLinkEvent struct {
+	Command UPBLinkCommand
+	Link    int
+}
+
+// AllOnOffEvent is sent when an all on or all off command is received for an area.
+type AllOnOffEvent struct {
+	On   bool
+	Area int
+}
+
+// PhoneLineEvent is sent when the phone line changes state.
+type PhoneLineEvent struct {
+	State PhoneLineState
+}
+
+// TroubleEvent is sent when an AC power, battery or digital communicator trouble occurs or clears.
+type TroubleEvent struct {
+	Trouble SystemTrouble
+	Cleared bool
+}
+
+// EnergyCostEvent is sent when the cost of energy changes.
+type EnergyCostEvent struct {
+	Cost EnergyCost
+}
+
+// CameraTriggerEvent is sent when a camera trigger is activated.
+type CameraTriggerEvent struct {
+	Camera int // 1-6
+}
+
+// UnknownEvent is an event word that does not match any event in the protocol.
+type UnknownEvent struct {
+	Event uint16
+}
+
+// Object status notifications are sent when the state of an object changes.
+
+type ZoneStatusEvent struct{ ZoneStatus }
+type UnitStatusEvent struct{ UnitStatus }
+type AreaStatusEvent struct{ AreaStatus }
+type ThermostatStatusEvent struct{ ThermostatStatus }
+type MessageStatusEvent struct{ MessageStatus }
+type AuxilarySensorStatusEvent struct{ AuxilarySensorStatus }
+type AudioZoneStatusEvent struct{ AudioZoneStatus }
+type ExpansionEnclosureStatusEvent struct{ ExpansionEnclosureStatus }
+type UserSettingStatusEvent struct{ UserSettingStatus }
+type AccessControlReaderStatusEvent struct{ AccessControlReaderStatus }
+type AccessControlLockStatusEvent struct{ AccessControlLockStatus }
+
+func (UserMacroButtonEvent) event()           {}
+func (ProLinkMessageEvent) event()            {}
+func (CentraLiteSwitchEvent) event()          {}
+func (ComposeCodeEvent) event()               {}
+func (X10CodeEvent) event()                   {}
+func (SwitchPressEvent) event()               {}
+func (UPBLinkEvent) event()                   {}
+func (AllOnOffEvent) event()                  {}
+func (PhoneLineEvent) event()                 {}
+func (TroubleEvent) event()                   {}
+func (EnergyCostEvent) event()                {}
+func (CameraTriggerEvent) event()             {}
+func (UnknownEvent) event()                   {}
+func (ZoneStatusEvent) event()                {}
+func (UnitStatusEvent) event()                {}
+func (AreaStatusEvent) event()                {}
+func (ThermostatStatusEvent) event()          {}
+func (MessageStatusEvent) event()             {}
+func (AuxilarySensorStatusEvent) event()      {}
+func (AudioZoneStatusEvent) event()           {}
+func (ExpansionEnclosureStatusEvent) event()  {}
+func (UserSettingStatusEvent) event()         {}
+func (AccessControlReaderStatusEvent) event() {}
+func (AccessControlLockStatusEvent) event()   {}
+
+func (e UserMacroButtonEvent) Code() uint16  { return uint16(e.Button) }
+func (e ProLinkMessageEvent) Code() uint16   { return 0x0100 | uint16(e.Message) }
+func (e CentraLiteSwitchEvent) Code() uint16 { return 0x0180 | uint16(e.Switch) }
+func (e ComposeCodeEvent) Code() uint16 {
+	return 0x7000 | uint16(e.State)<<8 | uint16(e.HouseCode-'A')<<4 | uint16(e.Unit-1)
+}
+func (e X10CodeEvent) Code() uint16 {
+	code := 0x0C00 | uint16(e.HouseCode-'A')<<4 | uint16(e.Unit-1)
+	if e.On {
+		code |= 0x0200
+	}
+	if e.AllUnits {
+		code |= 0x0100
+	}
+	return code
+}
+func (e SwitchPressEvent) Code() uint16 { return 0xF000 | uint16(e.Switch)<<8 | uint16(e.Unit) }
+func (e UPBLinkEvent) Code() uint16     { return 0xFC00 | uint16(e.Command)<<8 | uint16(e.Link) }
+func (e AllOnOffEvent) Code() uint16 {
+	code := 0x03E0 | uint16(e.Area)
+	if e.On {
+		code |= 0x0010
+	}
+	return code
+}
+func (e PhoneLineEvent) Code() uint16 { return 0x0300 | uint16(e.State) }
+func (e TroubleEvent) Code() uint16 {
+	var code uint16
+	switch e.Trouble {
+	case ACPower:
+		code = 0x0304
+	case BatteryLow:
+		code = 0x0306
+	case DigitalCommunicator:
+		code = 0x0308
+	}
+	if e.Cleared {
+		code++
+	}
+	return code
+}
+func (e EnergyCostEvent) Code() uint16    { return 0x030A + uint16(e.Cost) }
+func (e CameraTriggerEvent) Code() uint16 { return 0x030E + uint16(e.Camera-1) }
+func (e UnknownEvent) Code() uint16       { return e.Event }
+
+// DecodeEvent decodes a single 16-bit system event word.
+func DecodeEvent(code uint16) SystemEvent {
+	low := int(code & 0xFF)
+	switch {
+	case code <= 0x00FF:
+		return UserMacroButtonEvent{Button: low}
+	case code <= 0x017F:
+		return ProLinkMessageEvent{Message: int(code & 0x7F)}
+	case code <= 0x01FF:
+		return CentraLiteSwitchEvent{Switch: int(code & 0x7F)}
+	case code >= 0x0300 && code <= 0x0303:
+		return PhoneLineEvent{State: PhoneLineState(code - 0x0300)}
+	case code >= 0x0304 && code <= 0x0305:
+		return TroubleEvent{Trouble: ACPower, Cleared: code == 0x0305}
+	case code >= 0x0306 && code <= 0x0307:
+		return TroubleEvent{Trouble: BatteryLow, Cleared: code == 0x0307}
+	case code >= 0x0308 && code <= 0x0309:
+		return TroubleEvent{Trouble: DigitalCommunicator, Cleared: code == 0x0309}
+	case code >= 0x030A && code <= 0x030D:
+		return EnergyCostEvent{Cost: EnergyCost(code - 0x030A)}
+	case code >= 0x030E && code <= 0x0313:
+		return CameraTriggerEvent{Camera: int(code-0x030E) + 1}
+	case code >= 0x03E0 && code <= 0x03FF:
+		return AllOnOffEvent{On: code&0x0010 != 0, Area: int(code & 0x0F)}
+	case code >= 0x0C00 && code <= 0x0FFF:
+		return X10CodeEvent{
+			On:        code&0x0200 != 0,
+			AllUnits:  code&0x0100 != 0,
+			HouseCode: 'A' + byte(low>>4),
+			Unit:      low&0x0F + 1,
+		}
+	case code >= 0x7000 && code <= 0x7FFF:
+		return ComposeCodeEvent{
+			State:     int(code>>8) & 0x0F,
+			HouseCode: 'A' + byte(low>>4),
+			Unit:      low&0x0F + 1,
+		}
+	case code >= 0xFC00:
+		return UPBLinkEvent{Command: UPBLinkCommand(code>>8) & 0x03, Link: low}
+	case code >= 0xF000:
+		return SwitchPressEvent{Switch: int(code>>8) & 0x0F, Unit: low}
+	}
+	return UnknownEvent{Event: code}
+}
+
+// decodeSystemEvents unpacks a System Events message, oldest event first.
+func decodeSystemEvents(msg *proto.Msg) ([]Event, error) {
+	if len(msg.Data)%2 != 0 {
+		return nil, errors.Errorf("System events message has odd length %d", len(msg.Data))
+	}
+	events := make([]Event, 0, len(msg.Data)/2)
+	for i := 0; i < len(msg.Data); i += 2 {
+		events = append(events, DecodeEvent(binary.BigEndian.Uint16(msg.Data[i:])))
+	}
+	return events, nil
+}
+
+// decodeStatusEvents unpacks an unsolicited Object Status message into an event for each object.
+func decodeStatusEvents(msg *proto.Msg) ([]Event, error) {
+	if len(msg.Data) < 1 {
+		return nil, errors.New("Empty object status message")
+	}
+	objectType := ObjectType(msg.Data[0])
+	statusSize, ok := StatusSizes[objectType]
+	if !ok {
+		return nil, errors.Errorf("Object type %s has no status", objectType)
+	}
+	records := msg.Data[1:]
+	total := len(records) / statusSize
+
+	events := make([]Event, 0, total)
+	var err error
+	switch objectType {
+	case Zone:
+		st := make([]ZoneStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, ZoneStatusEvent{s})
+		}
+	case Unit:
+		st := make([]UnitStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, UnitStatusEvent{s})
+		}
+	case Area:
+		st := make([]AreaStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, AreaStatusEvent{s})
+		}
+	case Thermostat:
+		st := make([]ThermostatStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, ThermostatStatusEvent{s})
+		}
+	case Message:
+		st := make([]MessageStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, MessageStatusEvent{s})
+		}
+	case AuxilarySensor:
+		st := make([]AuxilarySensorStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, AuxilarySensorStatusEvent{s})
+		}
+	case AudioZone:
+		st := make([]AudioZoneStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, AudioZoneStatusEvent{s})
+		}
+	case ExpansionEnclosure:
+		st := make([]ExpansionEnclosureStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, ExpansionEnclosureStatusEvent{s})
+		}
+	case UserSetting:
+		st := make([]UserSettingStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, UserSettingStatusEvent{s})
+		}
+	case AccessControlReader:
+		st := make([]AccessControlReaderStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, AccessControlReaderStatusEvent{s})
+		}
+	case AccessControlLock:
+		st := make([]AccessControlLockStatus, total)
+		err = unmarshalRecords(records, st)
+		for _, s := range st {
+			events = append(events, AccessControlLockStatusEvent{s})
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal object status")
+	}
+	return events, nil
+}
+
+// Events enables event notifications on the controller and returns a channel of the system events and
+// object status changes it sends. A reconnecting Client enables notifications again on each new session.
+// The channel is closed when ctx is done, the Client is closed or, unless the Client reconnects, the connection fails.
+func (c *Client) Events(ctx context.Context) (<-chan Event, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to enable notifications")
+	}
+	msgs, cancel, err := enableNotifications(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		for {
+			more := forwardEvents(ctx, msgs, events)
+			cancel()
+			if !more || !c.reconnect {
+				return
+			}
+			// Wait for the next session and enable notifications on it
+			for {
+				conn, err := c.connection(ctx)
+				if err != nil {
+					return
+				}
+				msgs, cancel, err = enableNotifications(ctx, conn)
+				if err == nil {
+					break
+				}
+				select {
+				case <-conn.Done():
+				default:
+					// The session is ok but the controller refused
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// enableNotifications subscribes to the unsolicited messages on conn and asks the controller to send them.
+func enableNotifications(ctx context.Context, conn proto.Conn) (<-chan *proto.Msg, func(), error) {
+	msgs, cancel := conn.Subscribe()
+
+	m := &proto.Msg{
+		Type: proto.MsgEnableNotifications,
+		Data: []byte{1},
+	}
+	resp, err := sendOn(ctx, conn, m)
+	if err != nil {
+		cancel()
+		return nil, nil, errors.Wrap(err, "Failed to enable notifications")
+	}
+	if resp.Type != proto.MsgAck {
+		cancel()
+		return nil, nil, replyError(m, resp, "enable notifications")
+	}
+	return msgs, cancel, nil
+}
+
+// forwardEvents decodes unsolicited messages into events until the subscription ends or ctx is done.
+// It returns false if ctx is done.
+func forwardEvents(ctx context.Context, msgs <-chan *proto.Msg, events chan<- Event) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case msg, ok := <-msgs:
+			if !ok {
+				return true
+			}
+			var decoded []Event
+			var err error
+			switch msg.Type {
+			case proto.MsgSystemEvents:
+				decoded, err = decodeSystemEvents(msg)
+			case proto.MsgObjectStatus:
+				decoded, err = decodeStatusEvents(msg)
+			default:
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			for _, e := range decoded {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return false
+				}
+			}
+		}
+	}
+}
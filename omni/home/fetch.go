@@ -1,24 +1,40 @@
 package home
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/proto"
 )
 
-func New(logger *log.Logger, endpoint, key string) (*Home, error) {
-	c, err := omni.NewClient(fmt.Sprintf("%s:4369", endpoint), key)
+// New connects to the controller at endpoint, on the default port if it names none, and loads the Home.
+// Connection events are written to logger, which may be nil.
+func New(ctx context.Context, logger omni.Logger, endpoint, key string) (*Home, error) {
+	c, err := omni.NewClient(ctx, omni.ControllerAddr(endpoint), key, omni.WithLogger(logger))
 	if err != nil {
 		return nil, err
 	}
+	h, err := NewFromClient(ctx, logger, c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return h, nil
+}
 
-	h := Home{}
+// NewFromClient loads the controller's information and the properties of its named objects using an
+// existing client. Failed refreshes are written to logger, which may be nil.
+func NewFromClient(ctx context.Context, logger omni.Logger, c *omni.Client) (*Home, error) {
+	if logger == nil {
+		logger = proto.NopLogger{}
+	}
+	h := Home{client: c, logger: logger, changed: map[objectKey]uint64{}}
 
-	si, err := c.GetSystemInformation()
+	si, err := c.GetSystemInformation(ctx)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	h.ModelNumber = int(si.ModelNumber)
@@ -34,25 +50,117 @@ func New(logger *log.Logger, endpoint, key string) (*Home, error) {
 	}
 
 	h.Version = fmt.Sprintf("%d.%d", si.MajorVersion, si.MinorVersion)
-	h.PhoneNumber = string(si.LocalPhoneNumber[:])
+	h.PhoneNumber = omni.ObjectName(si.LocalPhoneNumber[:])
 
-	sf, err := c.GetSystemFeatures()
+	sf, err := c.GetSystemFeatures(ctx)
 	if err != nil {
 		return nil, err
 	}
 	h.features = sf.Features
 
-	ss, err := c.GetSystemStatus()
+	h.formats, err = c.GetSystemFormats(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	err = h.loadObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = h.refreshSystem(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Messages are the only objects whose properties do not include their status
+	err = h.refreshMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// loadObjects reads the properties, which include the current status, of the named objects.
+func (h *Home) loadObjects(ctx context.Context) error {
+	c := h.client
+
+	zones, err := c.Zones(ctx)
+	if err != nil {
+		return err
+	}
+	h.zones = map[int]*Zone{}
+	for _, p := range zones {
+		z := zoneFromProperties(p)
+		h.zones[z.Number] = &z
+	}
+
+	units, err := c.Units(ctx)
+	if err != nil {
+		return err
+	}
+	h.units = map[int]*Unit{}
+	for _, p := range units {
+		u := unitFromProperties(p)
+		h.units[u.Number] = &u
+	}
+
+	areas, err := c.Areas(ctx)
+	if err != nil {
+		return err
+	}
+	h.areas = map[int]*Area{}
+	for _, p := range areas {
+		a := areaFromProperties(p)
+		h.areas[a.Number] = &a
+	}
+
+	thermostats, err := c.Thermostats(ctx)
+	if err != nil {
+		return err
+	}
+	h.thermostats = map[int]*Thermostat{}
+	for _, p := range thermostats {
+		t := thermostatFromProperties(p)
+		h.thermostats[t.Number] = &t
+	}
+
+	buttons, err := c.Buttons(ctx)
+	if err != nil {
+		return err
+	}
+	h.buttons = map[int]*Button{}
+	for _, p := range buttons {
+		b := Button{Number: omni.ObjectNumber(p.NumberMSB, p.NumberLSB), Name: omni.ObjectName(p.Name[:])}
+		h.buttons[b.Number] = &b
+	}
+
+	messages, err := c.Messages(ctx)
+	if err != nil {
+		return err
+	}
+	h.messages = map[int]*Message{}
+	for _, p := range messages {
+		m := Message{Number: omni.ObjectNumber(p.NumberMSB, p.NumberLSB), Name: omni.ObjectName(p.Name[:])}
+		h.messages[m.Number] = &m
+	}
+
+	audioZones, err := c.AudioZones(ctx)
+	if err != nil {
+		return err
+	}
+	h.audioZones = map[int]*AudioZone{}
+	for _, p := range audioZones {
+		a := audioZoneFromProperties(p)
+		h.audioZones[a.Number] = &a
+	}
+	return nil
+}
+
+func statusFrom(ss omni.SystemStatus) Status {
 	st := Status{}
 	if int(ss.DateValid) > 0 {
 		st.DateSet = true
 		st.Date = time.Date(2000+int(ss.Year), time.Month(ss.Month), int(ss.Day), int(ss.Hour), int(ss.Minute), int(ss.Second), 0, time.UTC)
 	}
 	st.Battery = ss.Battery
-	h.latestStatus = st
-	return &h, nil
+	return st
 }
@@ -2,24 +2,46 @@ package home
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/leelynne/omnilink/omni"
 )
 
+// Home is an in-memory view of the controller and its named objects. It is loaded by New and kept
+// current by Run. Accessors return copies so they are safe to use while the Home is updated.
 type Home struct {
 	ModelNumber int
 	ModelName   string
 	Version     string
 	PhoneNumber string
 	features    []omni.SystemFeature
+	client      *omni.Client
+	logger      omni.Logger
 
 	mu           sync.Mutex
-	thermostats  []Thermostat
+	formats      omni.SystemFormats
+	zones        map[int]*Zone
+	units        map[int]*Unit
+	areas        map[int]*Area
+	thermostats  map[int]*Thermostat
+	buttons      map[int]*Button
+	messages     map[int]*Message
+	audioZones   map[int]*AudioZone
 	latestStatus Status
 	troubles     []omni.SystemTrouble
+	events       uint64               // Number of events applied
+	changed      map[objectKey]uint64 // Value of events when each object was last changed by an event
+
+	zoneFuncs       []func(old, new Zone)
+	unitFuncs       []func(old, new Unit)
+	areaFuncs       []func(old, new Area)
+	areaModeFuncs   []func(old, new Area)
+	thermostatFuncs []func(old, new Thermostat)
+	troubleFuncs    []func(trouble omni.SystemTrouble, active bool)
 }
 
 func (h *Home) Features() []Feature {
@@ -33,8 +55,179 @@ func (h *Home) Features() []Feature {
 
 	return features
 }
-func (h *Home) Thermostats() ([]Thermostat, error) {
-	return nil, nil
+
+// SyncTime sets the controller clock to the host's local time, including its daylight savings time flag.
+func (h *Home) SyncTime(ctx context.Context) error {
+	now := time.Now()
+	err := h.client.SetTime(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latestStatus.DateSet = true
+	h.latestStatus.Date = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.UTC)
+	return nil
+}
+
+// Formats returns the controller's temperature, time and date formats.
+func (h *Home) Formats() omni.SystemFormats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.formats
+}
+
+// Status returns the controller's clock and battery reading as of the last refresh.
+func (h *Home) Status() Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.latestStatus
+}
+
+// Troubles returns the current system troubles.
+func (h *Home) Troubles() []omni.SystemTrouble {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]omni.SystemTrouble{}, h.troubles...)
+}
+
+// Zones returns the named zones ordered by number.
+func (h *Home) Zones() []Zone {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	zones := make([]Zone, 0, len(h.zones))
+	for _, o := range h.zones {
+		zones = append(zones, *o)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Number < zones[j].Number })
+	return zones
+}
+
+// Zone returns the zone with the given number.
+func (h *Home) Zone(number int) (Zone, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if z, ok := h.zones[number]; ok {
+		return *z, true
+	}
+	return Zone{}, false
+}
+
+// Units returns the named units ordered by number.
+func (h *Home) Units() []Unit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	units := make([]Unit, 0, len(h.units))
+	for _, o := range h.units {
+		units = append(units, *o)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Number < units[j].Number })
+	return units
+}
+
+// Unit returns the unit with the given number.
+func (h *Home) Unit(number int) (Unit, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if u, ok := h.units[number]; ok {
+		return *u, true
+	}
+	return Unit{}, false
+}
+
+// Areas returns the named areas ordered by number.
+func (h *Home) Areas() []Area {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	areas := make([]Area, 0, len(h.areas))
+	for _, o := range h.areas {
+		areas = append(areas, *o)
+	}
+	sort.Slice(areas, func(i, j int) bool { return areas[i].Number < areas[j].Number })
+	return areas
+}
+
+// Area returns the area with the given number.
+func (h *Home) Area(number int) (Area, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if a, ok := h.areas[number]; ok {
+		return *a, true
+	}
+	return Area{}, false
+}
+
+// Thermostats returns the named thermostats ordered by number.
+func (h *Home) Thermostats() []Thermostat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	thermostats := make([]Thermostat, 0, len(h.thermostats))
+	for _, o := range h.thermostats {
+		thermostats = append(thermostats, *o)
+	}
+	sort.Slice(thermostats, func(i, j int) bool { return thermostats[i].Number < thermostats[j].Number })
+	return thermostats
+}
+
+// Thermostat returns the thermostat with the given number.
+func (h *Home) Thermostat(number int) (Thermostat, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if t, ok := h.thermostats[number]; ok {
+		return *t, true
+	}
+	return Thermostat{}, false
+}
+
+// Buttons returns the named buttons ordered by number.
+func (h *Home) Buttons() []Button {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buttons := make([]Button, 0, len(h.buttons))
+	for _, o := range h.buttons {
+		buttons = append(buttons, *o)
+	}
+	sort.Slice(buttons, func(i, j int) bool { return buttons[i].Number < buttons[j].Number })
+	return buttons
+}
+
+// Messages returns the named messages ordered by number.
+func (h *Home) Messages() []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	messages := make([]Message, 0, len(h.messages))
+	for _, o := range h.messages {
+		messages = append(messages, *o)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Number < messages[j].Number })
+	return messages
+}
+
+// AudioZones returns the named audio zones ordered by number.
+func (h *Home) AudioZones() []AudioZone {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	audioZones := make([]AudioZone, 0, len(h.audioZones))
+	for _, o := range h.audioZones {
+		audioZones = append(audioZones, *o)
+	}
+	sort.Slice(audioZones, func(i, j int) bool { return audioZones[i].Number < audioZones[j].Number })
+	return audioZones
 }
 
 func (h *Home) String() string {
@@ -45,7 +238,15 @@ func (h *Home) String() string {
 	buf.WriteString(fmt.Sprintf("Version: %s\n", h.Version))
 	buf.WriteString(fmt.Sprintf("Phone: %s\n", h.PhoneNumber))
 	buf.WriteString(fmt.Sprintf("Features: %s\n", h.Features()))
-	buf.WriteString(fmt.Sprintf("Status: %s\n", h.latestStatus))
+	buf.WriteString(fmt.Sprintf("Status: %s\n", h.Status()))
+	buf.WriteString(fmt.Sprintf("Troubles: %v\n", h.Troubles()))
+	buf.WriteString(fmt.Sprintf("Zones: %d\n", len(h.Zones())))
+	buf.WriteString(fmt.Sprintf("Units: %d\n", len(h.Units())))
+	buf.WriteString(fmt.Sprintf("Areas: %d\n", len(h.Areas())))
+	buf.WriteString(fmt.Sprintf("Thermostats: %d\n", len(h.Thermostats())))
+	buf.WriteString(fmt.Sprintf("Buttons: %d\n", len(h.Buttons())))
+	buf.WriteString(fmt.Sprintf("Messages: %d\n", len(h.Messages())))
+	buf.WriteString(fmt.Sprintf("Audio Zones: %d\n", len(h.AudioZones())))
 
 	return buf.String()
 }
@@ -59,9 +260,6 @@ func (f Feature) String() string {
 	return f.Name
 }
 
-type Thermostat struct {
-}
-
 type Status struct {
 	DateSet bool
 	Date    time.Time
@@ -0,0 +1,237 @@
+package home
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/omnisim"
+)
+
+// newTestHome starts a simulator of inst and loads a Home from it. The client and simulator are closed
+// by the returned function.
+func newTestHome(t *testing.T, inst *omnisim.Installation) (*omnisim.Simulator, *Home, func()) {
+	t.Helper()
+	sim, err := omnisim.ListenDemo(inst)
+	if err != nil {
+		t.Fatalf("Failed to start simulator: %s", err)
+	}
+	ctx, cancel := testContext()
+	defer cancel()
+	c, err := omni.NewClient(ctx, sim.Addr(), omnisim.DemoKey)
+	if err != nil {
+		sim.Close()
+		t.Fatalf("Failed to connect to simulator: %s", err)
+	}
+	h, err := NewFromClient(ctx, nil, c)
+	if err != nil {
+		c.Close()
+		sim.Close()
+		t.Fatalf("NewFromClient failed: %s", err)
+	}
+	return sim, h, func() {
+		c.Close()
+		sim.Close()
+	}
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+// run applies events from the controller to h until the returned function is called.
+func run(h *Home) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Run(ctx, 0)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitFor calls send, which makes the simulator send an event, until cond holds. The event is resent as
+// Run may not have enabled notifications yet.
+func waitFor(t *testing.T, send func(), cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the Home to change")
+		}
+		send()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	_, h, done := newTestHome(t, omnisim.Demo())
+	defer done()
+
+	if h.ModelName != "HAI Omni IIe" || h.Version != "3.14" {
+		t.Errorf("Loaded model %q version %s", h.ModelName, h.Version)
+	}
+	if f := h.Features(); len(f) != 1 || f[0].Type != int(omni.HAIHiFi) {
+		t.Errorf("Loaded features %v", f)
+	}
+	if st := h.Status(); !st.DateSet || st.Date != time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC) || st.Battery != 200 {
+		t.Errorf("Loaded status %+v", st)
+	}
+	if tr := h.Troubles(); !reflect.DeepEqual(tr, []omni.SystemTrouble{omni.ACPower}) {
+		t.Errorf("Loaded troubles %v", tr)
+	}
+
+	zones := h.Zones()
+	if len(zones) != 3 {
+		t.Fatalf("Loaded %d zones, want 3", len(zones))
+	}
+	if z := zones[0]; z.Number != 1 || z.Name != "FRONT DOOR" || z.Area != 1 || z.LoopReading != 120 || !z.Secure() {
+		t.Errorf("Loaded zone %+v", z)
+	}
+	if z := zones[1]; z.Name != "BACK DOOR" || z.Secure() {
+		t.Errorf("Loaded zone %+v", z)
+	}
+	units := h.Units()
+	if len(units) != 120 || units[119].Number != 120 || units[119].Name != "LIGHT" || units[119].Time != 120 {
+		t.Errorf("Loaded %d units ending with %+v", len(units), units[len(units)-1])
+	}
+	if a, ok := h.Area(1); !ok || a.Name != "HOUSE" || a.Mode != omni.Disarmed {
+		t.Errorf("Loaded area %+v", a)
+	}
+	th, ok := h.Thermostat(1)
+	if !ok || th.Name != "DOWNSTAIRS" || th.Temperature.Celsius() != 21 || th.Mode != omni.ThermostatAuto || th.Humidity != 45 {
+		t.Errorf("Loaded thermostat %+v", th)
+	}
+	if _, ok := h.Unit(121); ok {
+		t.Error("Loaded unit 121, which is not configured")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	sim, h, done := newTestHome(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	sim.Update(func(inst *omnisim.Installation) {
+		inst.Status.Battery = 180
+		inst.Zones[1].Status = 0x01
+		inst.Units[5].State = 150
+		inst.Areas[1].Mode = omni.Away
+		inst.Thermostats[1].Temperature = 126
+		inst.Thermostats[1].Humidity = 50
+	})
+	err := h.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh failed: %s", err)
+	}
+	if st := h.Status(); st.Battery != 180 {
+		t.Errorf("Battery is %d after refresh, want 180", st.Battery)
+	}
+	if z, _ := h.Zone(1); z.Secure() {
+		t.Errorf("Zone 1 is %+v after refresh, want not ready", z)
+	}
+	if u, _ := h.Unit(5); u.Level() != 50 {
+		t.Errorf("Unit 5 level is %d after refresh, want 50", u.Level())
+	}
+	if a, _ := h.Area(1); a.Mode != omni.Away {
+		t.Errorf("Area 1 mode is %s after refresh, want away", a.Mode)
+	}
+	if th, _ := h.Thermostat(1); th.Temperature.Celsius() != 23 || th.Humidity != 50 {
+		t.Errorf("Thermostat is %+v after refresh, want 23°C and humidity 50", th)
+	}
+}
+
+// TestUpdatePolled polls a unit's status, changes it with an event and then applies the polled status,
+// which must not undo the newer state from the event.
+func TestUpdatePolled(t *testing.T) {
+	sim, h, done := newTestHome(t, omnisim.Demo())
+	defer done()
+	stop := run(h)
+	defer stop()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	since := h.eventCount()
+	polled, err := h.client.UnitStatus(ctx, omni.Range{Start: 5, End: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Update(func(inst *omnisim.Installation) { inst.Units[5].State = 1 })
+	waitFor(t, func() { sim.NotifyStatus(omni.Unit, 5) }, func() bool {
+		u, _ := h.Unit(5)
+		return u.On()
+	})
+	h.updatePolled(since, omni.UnitStatusEvent{UnitStatus: polled[0]})
+	if u, _ := h.Unit(5); !u.On() {
+		t.Error("Status polled before an event replaced the status from the event")
+	}
+
+	// Status polled after the event is applied
+	h.updatePolled(h.eventCount(), omni.UnitStatusEvent{UnitStatus: polled[0]})
+	if u, _ := h.Unit(5); u.On() {
+		t.Error("Status polled after an event was not applied")
+	}
+}
+
+// TestThermostatFallback refreshes thermostats from a controller on firmware 2, which naks the extended
+// status request.
+func TestThermostatFallback(t *testing.T) {
+	inst := omnisim.Demo()
+	inst.Info.MajorVersion = 2
+	sim, h, done := newTestHome(t, inst)
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	sim.Update(func(inst *omnisim.Installation) {
+		inst.Thermostats[1].HeatSetPoint = 116
+		inst.Thermostats[1].Humidity = 60
+	})
+	err := h.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh failed: %s", err)
+	}
+	th, _ := h.Thermostat(1)
+	if th.HeatSetPoint.Celsius() != 18 {
+		t.Errorf("Heat setpoint is %s after refresh, want 18°C", th.HeatSetPoint.Format(omni.Celsius))
+	}
+	// Only the extended status reports humidity
+	if th.Humidity != 45 {
+		t.Errorf("Humidity is %d after refresh, want 45 from the properties", th.Humidity)
+	}
+}
+
+// TestTroubles changes the system troubles with events and a refresh.
+func TestTroubles(t *testing.T) {
+	sim, h, done := newTestHome(t, omnisim.Demo())
+	defer done()
+	stop := run(h)
+	defer stop()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	troubles := func(want ...omni.SystemTrouble) func() bool {
+		return func() bool { return reflect.DeepEqual(h.Troubles(), want) }
+	}
+	waitFor(t, func() { sim.SendEvents(omni.TroubleEvent{Trouble: omni.BatteryLow}.Code()) },
+		troubles(omni.ACPower, omni.BatteryLow))
+	waitFor(t, func() { sim.SendEvents(omni.TroubleEvent{Trouble: omni.ACPower, Cleared: true}.Code()) },
+		troubles(omni.BatteryLow))
+
+	sim.Update(func(inst *omnisim.Installation) {
+		inst.Troubles = []omni.SystemTrouble{omni.Freeze, omni.BatteryLow}
+	})
+	err := h.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Refresh failed: %s", err)
+	}
+	if !troubles(omni.Freeze, omni.BatteryLow)() {
+		t.Errorf("Troubles are %v after refresh, want Freeze and BatteryLow", h.Troubles())
+	}
+}
@@ -0,0 +1,142 @@
+package home
+
+import "github.com/leelynne/omnilink/omni"
+
+// Change functions are called with the old and new values of an object whenever a refresh or an event
+// from the controller alters it. They are called in the order they were added, on the goroutine which
+// applied the change and without holding the Home's lock, so they may use its accessors. Functions
+// should return quickly as later changes wait for them.
+
+// OnZoneChange adds a function called when the status of a zone changes.
+func (h *Home) OnZoneChange(f func(old, new Zone)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.zoneFuncs = append(h.zoneFuncs, f)
+}
+
+// OnUnitChange adds a function called when the state of a unit changes.
+func (h *Home) OnUnitChange(f func(old, new Unit)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unitFuncs = append(h.unitFuncs, f)
+}
+
+// OnAreaChange adds a function called when the status of an area changes, including its alarms and timers.
+func (h *Home) OnAreaChange(f func(old, new Area)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.areaFuncs = append(h.areaFuncs, f)
+}
+
+// OnAreaModeChange adds a function called when the security mode of an area changes.
+func (h *Home) OnAreaModeChange(f func(old, new Area)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.areaModeFuncs = append(h.areaModeFuncs, f)
+}
+
+// OnThermostatChange adds a function called when the status of a thermostat changes.
+func (h *Home) OnThermostatChange(f func(old, new Thermostat)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.thermostatFuncs = append(h.thermostatFuncs, f)
+}
+
+// OnTrouble adds a function called when a system trouble occurs, with active set, or clears.
+func (h *Home) OnTrouble(f func(trouble omni.SystemTrouble, active bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.troubleFuncs = append(h.troubleFuncs, f)
+}
+
+// The changed functions return the calls to make to the change functions, if the object changed.
+// The caller must hold h.mu.
+
+func (h *Home) zoneChanged(old, new Zone) []func() {
+	if old == new {
+		return nil
+	}
+	notify := make([]func(), len(h.zoneFuncs))
+	for i, f := range h.zoneFuncs {
+		f := f
+		notify[i] = func() { f(old, new) }
+	}
+	return notify
+}
+
+func (h *Home) unitChanged(old, new Unit) []func() {
+	if old == new {
+		return nil
+	}
+	notify := make([]func(), len(h.unitFuncs))
+	for i, f := range h.unitFuncs {
+		f := f
+		notify[i] = func() { f(old, new) }
+	}
+	return notify
+}
+
+func (h *Home) areaChanged(old, new Area) []func() {
+	if old == new {
+		return nil
+	}
+	notify := make([]func(), 0, len(h.areaFuncs)+len(h.areaModeFuncs))
+	for _, f := range h.areaFuncs {
+		f := f
+		notify = append(notify, func() { f(old, new) })
+	}
+	if old.Mode == new.Mode {
+		return notify
+	}
+	for _, f := range h.areaModeFuncs {
+		f := f
+		notify = append(notify, func() { f(old, new) })
+	}
+	return notify
+}
+
+func (h *Home) thermostatChanged(old, new Thermostat) []func() {
+	if old == new {
+		return nil
+	}
+	notify := make([]func(), len(h.thermostatFuncs))
+	for i, f := range h.thermostatFuncs {
+		f := f
+		notify[i] = func() { f(old, new) }
+	}
+	return notify
+}
+
+// setTroubles replaces the current troubles and returns the calls for each trouble which occurred or cleared.
+func (h *Home) setTroubles(troubles []omni.SystemTrouble) []func() {
+	previous := h.troubles
+	h.troubles = troubles
+
+	notify := []func(){}
+	// changed adds calls for the troubles in from which are not in to, reporting each trouble once
+	changed := func(from, to []omni.SystemTrouble, active bool) {
+		seen := map[omni.SystemTrouble]bool{}
+		for _, t := range to {
+			seen[t] = true
+		}
+		for _, t := range from {
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			for _, f := range h.troubleFuncs {
+				f, t := f, t
+				notify = append(notify, func() { f(t, active) })
+			}
+		}
+	}
+	changed(previous, troubles, false)
+	changed(troubles, previous, true)
+	return notify
+}
@@ -0,0 +1,120 @@
+package home
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/omnisim"
+)
+
+// record adds change functions to h which append a description of each call to the returned slice.
+func record(h *Home) *[]string {
+	calls := &[]string{}
+	add := func(format string, v ...interface{}) {
+		*calls = append(*calls, fmt.Sprintf(format, v...))
+	}
+	h.OnZoneChange(func(old, new Zone) {
+		add("zone %d status %d to %d", new.Number, old.Status, new.Status)
+	})
+	h.OnUnitChange(func(old, new Unit) {
+		add("unit %d state %d to %d", new.Number, old.State, new.State)
+	})
+	h.OnAreaChange(func(old, new Area) {
+		add("area %d mode %s alarms %d to mode %s alarms %d", new.Number, old.Mode, old.Alarms, new.Mode, new.Alarms)
+	})
+	h.OnAreaModeChange(func(old, new Area) {
+		add("area %d mode %s to %s", new.Number, old.Mode, new.Mode)
+	})
+	h.OnThermostatChange(func(old, new Thermostat) {
+		add("thermostat %d heat setpoint %d to %d", new.Number, old.HeatSetPoint, new.HeatSetPoint)
+	})
+	h.OnTrouble(func(trouble omni.SystemTrouble, active bool) {
+		add("trouble %s active %t", trouble, active)
+	})
+	return calls
+}
+
+func TestChangeFuncs(t *testing.T) {
+	sim, h, done := newTestHome(t, omnisim.Demo())
+	defer done()
+	ctx, cancel := testContext()
+	defer cancel()
+	calls := record(h)
+
+	tests := []struct {
+		name   string
+		update func(inst *omnisim.Installation)
+		want   []string
+	}{
+		{"nothing", func(inst *omnisim.Installation) {}, nil},
+		{
+			"zone and unit",
+			func(inst *omnisim.Installation) {
+				inst.Zones[3].Status = 0x01
+				inst.Units[7].State = 1
+			},
+			[]string{"zone 3 status 0 to 1", "unit 7 state 0 to 1"},
+		},
+		{
+			"area alarm",
+			func(inst *omnisim.Installation) { inst.Areas[1].Alarms = 0x01 },
+			[]string{"area 1 mode Disarmed alarms 0 to mode Disarmed alarms 1"},
+		},
+		{
+			"area mode",
+			func(inst *omnisim.Installation) { inst.Areas[1].Mode = omni.Night },
+			[]string{"area 1 mode Disarmed alarms 1 to mode Night alarms 1", "area 1 mode Disarmed to Night"},
+		},
+		{
+			"thermostat",
+			func(inst *omnisim.Installation) { inst.Thermostats[1].HeatSetPoint = 118 },
+			[]string{"thermostat 1 heat setpoint 120 to 118"},
+		},
+		{
+			// The controller may list a trouble more than once
+			"troubles",
+			func(inst *omnisim.Installation) {
+				inst.Troubles = []omni.SystemTrouble{omni.BatteryLow, omni.Freeze, omni.BatteryLow}
+			},
+			[]string{"trouble ACPower active false", "trouble BatteryLow active true", "trouble Freeze active true"},
+		},
+		{"troubles reordered", func(inst *omnisim.Installation) {
+			inst.Troubles = []omni.SystemTrouble{omni.Freeze, omni.BatteryLow}
+		}, nil},
+		{"nothing again", func(inst *omnisim.Installation) {}, nil},
+	}
+	for _, test := range tests {
+		*calls = nil
+		sim.Update(test.update)
+		err := h.Refresh(ctx)
+		if err != nil {
+			t.Fatalf("Refresh after %s failed: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(*calls, test.want) {
+			t.Errorf("Refresh after %s called %q, want %q", test.name, *calls, test.want)
+		}
+	}
+}
+
+// TestTroubleEvents checks that trouble events call the trouble functions only when the trouble changes.
+func TestTroubleEvents(t *testing.T) {
+	_, h, done := newTestHome(t, omnisim.Demo())
+	defer done()
+	calls := record(h)
+
+	for _, e := range []omni.TroubleEvent{
+		{Trouble: omni.BatteryLow},
+		{Trouble: omni.BatteryLow},
+		{Trouble: omni.ACPower},
+		{Trouble: omni.ACPower, Cleared: true},
+		{Trouble: omni.ACPower, Cleared: true},
+	} {
+		h.update(e)
+	}
+	want := []string{"trouble BatteryLow active true", "trouble ACPower active false"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("Trouble events called %q, want %q", *calls, want)
+	}
+}
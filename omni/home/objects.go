@@ -0,0 +1,228 @@
+package home
+
+import (
+	"github.com/leelynne/omnilink/omni"
+)
+
+// Zone is a security zone.
+type Zone struct {
+	Number      int
+	Name        string
+	Type        uint8
+	Area        int
+	Status      uint8 // Bits 0-1 are the current condition, 2-3 the latched alarm and 4-5 the arming status
+	LoopReading uint8
+}
+
+// Secure reports whether the zone's current condition is secure.
+func (z Zone) Secure() bool {
+	return z.Status&0x03 == 0
+}
+
+// Trouble reports whether the zone currently has a trouble condition.
+func (z Zone) Trouble() bool {
+	return z.Status&0x03 == 2
+}
+
+// Tripped reports whether the zone is latched in alarm.
+func (z Zone) Tripped() bool {
+	return z.Status&0x0C == 0x04
+}
+
+// Bypassed reports whether the zone is bypassed by a user or by the system.
+func (z Zone) Bypassed() bool {
+	return z.Status&0x20 != 0
+}
+
+// Unit is a lighting or appliance control unit, flag or output.
+type Unit struct {
+	Number int
+	Name   string
+	Type   uint8
+	State  uint8 // 0 is off, 1 is on and 100-200 is a 0-100 percent level, other values depend on the unit type
+	Time   int   // Seconds remaining on a timed command
+}
+
+// On reports whether the unit was last commanded on or to a non-zero level.
+func (u Unit) On() bool {
+	return u.State != 0 && u.State != 100
+}
+
+// Level returns the unit's brightness in percent. Units without levels report 100 when on.
+func (u Unit) Level() int {
+	switch {
+	case u.State >= 100 && u.State <= 200:
+		return int(u.State) - 100
+	case u.On():
+		return 100
+	}
+	return 0
+}
+
+// Area is a security area.
+type Area struct {
+	Number     int
+	Name       string
+	Mode       omni.SecurityMode
+	Alarms     uint8 // Bits 0-7 are set for burglary, fire, gas, auxiliary, freeze, water, duress and temperature alarms
+	EntryTimer int   // Seconds remaining
+	ExitTimer  int   // Seconds remaining
+}
+
+// Thermostat is a thermostat with optional humidity control. The humidity and outdoor temperature fields
+// require controller firmware 3.0 or later.
+type Thermostat struct {
+	Number             int
+	Name               string
+	Type               uint8
+	Status             uint8 // Bit 0 is set on a communication failure and bit 1 on a freeze alarm
+	Temperature        omni.Temperature
+	HeatSetPoint       omni.Temperature
+	CoolSetPoint       omni.Temperature
+	Mode               omni.ThermostatMode
+	FanMode            omni.FanMode
+	Hold               bool
+	Humidity           omni.Humidity
+	HumidifySetPoint   omni.Humidity
+	DehumidifySetPoint omni.Humidity
+	OutdoorTemperature omni.Temperature
+	ActionStatus       uint8 // Bits 0-3 are set while heating, cooling, humidifying and dehumidifying
+}
+
+// Communicating reports whether the controller can reach the thermostat.
+func (t Thermostat) Communicating() bool {
+	return t.Status&0x01 == 0
+}
+
+// Button is a macro button.
+type Button struct {
+	Number int
+	Name   string
+}
+
+// Message is a text message which can be shown on consoles.
+type Message struct {
+	Number int
+	Name   string
+	Status uint8 // 0 is not displayed, 1 is displayed and 2 is displayed but not acknowledged
+}
+
+// AudioZone is a zone of an audio system.
+type AudioZone struct {
+	Number int
+	Name   string
+	On     bool
+	Source int
+	Volume int // Percent
+	Mute   bool
+}
+
+func zoneFromProperties(p omni.ZoneProperties) Zone {
+	return Zone{
+		Number:      omni.ObjectNumber(p.NumberMSB, p.NumberLSB),
+		Name:        omni.ObjectName(p.Name[:]),
+		Type:        p.Type,
+		Area:        int(p.Area),
+		Status:      p.Status,
+		LoopReading: p.LoopReading,
+	}
+}
+
+func unitFromProperties(p omni.UnitProperties) Unit {
+	return Unit{
+		Number: omni.ObjectNumber(p.NumberMSB, p.NumberLSB),
+		Name:   omni.ObjectName(p.Name[:]),
+		Type:   p.Type,
+		State:  p.State,
+		Time:   omni.ObjectNumber(p.TimeMSB, p.TimeLSB),
+	}
+}
+
+func areaFromProperties(p omni.AreaProperties) Area {
+	return Area{
+		Number:     omni.ObjectNumber(p.NumberMSB, p.NumberLSB),
+		Name:       omni.ObjectName(p.Name[:]),
+		Mode:       omni.SecurityMode(p.Mode),
+		Alarms:     p.Alarms,
+		EntryTimer: int(p.EntryTimer),
+		ExitTimer:  int(p.ExitTimer),
+	}
+}
+
+func thermostatFromProperties(p omni.ThermostatProperties) Thermostat {
+	return Thermostat{
+		Number:             omni.ObjectNumber(p.NumberMSB, p.NumberLSB),
+		Name:               omni.ObjectName(p.Name[:]),
+		Type:               p.Type,
+		Status:             p.Communicating,
+		Temperature:        p.Temperature,
+		HeatSetPoint:       p.HeatSetPoint,
+		CoolSetPoint:       p.CoolSetPoint,
+		Mode:               omni.ThermostatMode(p.SystemMode),
+		FanMode:            omni.FanMode(p.FanMode),
+		Hold:               p.HoldStatus != 0,
+		Humidity:           p.Humidty,
+		HumidifySetPoint:   p.HumidifySetPoint,
+		DehumidifySetPoint: p.DehumidifySetPoint,
+		OutdoorTemperature: p.OutdoorTemperature,
+		ActionStatus:       p.ActionStatus,
+	}
+}
+
+func audioZoneFromProperties(p omni.AudioZoneProperties) AudioZone {
+	return AudioZone{
+		Number: omni.ObjectNumber(p.NumberMSB, p.NumberLSB),
+		Name:   omni.ObjectName(p.Name[:]),
+		On:     p.On != 0,
+		Source: int(p.Source),
+		Volume: int(p.Volume),
+		Mute:   p.Mute != 0,
+	}
+}
+
+func (z *Zone) update(st omni.ZoneStatus) {
+	z.Status = st.Status
+	z.LoopReading = st.LoopReading
+}
+
+func (u *Unit) update(st omni.UnitStatus) {
+	u.State = st.State
+	u.Time = omni.ObjectNumber(st.TimeMSB, st.TimeLSB)
+}
+
+func (a *Area) update(st omni.AreaStatus) {
+	a.Mode = omni.SecurityMode(st.Mode)
+	a.Alarms = st.Alarms
+	a.EntryTimer = int(st.EntryTimer)
+	a.ExitTimer = int(st.ExitTimer)
+}
+
+func (t *Thermostat) update(st omni.ThermostatStatus) {
+	t.Status = st.Status
+	t.Temperature = st.CurrentTemp
+	t.HeatSetPoint = st.HeatSetPoint
+	t.CoolSetPoint = st.CoolSetPoint
+	t.Mode = omni.ThermostatMode(st.SystemMode)
+	t.FanMode = omni.FanMode(st.FanMode)
+	t.Hold = st.HoldStatus != 0
+}
+
+func (t *Thermostat) updateExtended(st omni.ExtendedThermostatStatus) {
+	t.update(st.ThermostatStatus)
+	t.Humidity = st.Humidity
+	t.HumidifySetPoint = st.HumidifySetPoint
+	t.DehumidifySetPoint = st.DehumidifySetPoint
+	t.OutdoorTemperature = st.OutdoorTemperature
+	t.ActionStatus = st.ActionStatus
+}
+
+func (m *Message) update(st omni.MessageStatus) {
+	m.Status = st.Status
+}
+
+func (a *AudioZone) update(st omni.AudioZoneStatus) {
+	a.On = st.On != 0
+	a.Source = int(st.Source)
+	a.Volume = int(st.Volume)
+	a.Mute = st.Mute != 0
+}
@@ -0,0 +1,336 @@
+package home
+
+import (
+	"context"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/pkg/errors"
+)
+
+// Run keeps the Home current until ctx is done, applying the status changes and troubles sent by the
+// controller. If interval is positive the Home is also refreshed at that interval, which catches changes
+// missed while a reconnecting client was disconnected.
+func (h *Home) Run(ctx context.Context, interval time.Duration) error {
+	events, err := h.client.Events(ctx)
+	if err != nil {
+		return err
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return errors.New("Controller event stream closed")
+			}
+			h.update(e)
+		case <-tick:
+			err := h.Refresh(ctx)
+			if err != nil {
+				h.logger.Warn("Failed to refresh home", "err", err)
+			}
+		}
+	}
+}
+
+// Refresh polls the controller for the system status, troubles and the status of every object in the Home.
+// Objects changed by an event while their status was being polled keep the newer status from the event.
+func (h *Home) Refresh(ctx context.Context) error {
+	err := h.refreshSystem(ctx)
+	if err != nil {
+		return err
+	}
+	c := h.client
+	ranges := h.ranges()
+
+	if r, ok := ranges[omni.Zone]; ok {
+		since := h.eventCount()
+		st, err := c.ZoneStatus(ctx, r)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			h.updatePolled(since, omni.ZoneStatusEvent{ZoneStatus: s})
+		}
+	}
+	if r, ok := ranges[omni.Unit]; ok {
+		since := h.eventCount()
+		st, err := c.UnitStatus(ctx, r)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			h.updatePolled(since, omni.UnitStatusEvent{UnitStatus: s})
+		}
+	}
+	if r, ok := ranges[omni.Area]; ok {
+		since := h.eventCount()
+		st, err := c.AreaStatus(ctx, r)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			h.updatePolled(since, omni.AreaStatusEvent{AreaStatus: s})
+		}
+	}
+	if r, ok := ranges[omni.Thermostat]; ok {
+		err := h.refreshThermostats(ctx, r)
+		if err != nil {
+			return err
+		}
+	}
+	err = h.refreshMessages(ctx)
+	if err != nil {
+		return err
+	}
+	if r, ok := ranges[omni.AudioZone]; ok {
+		since := h.eventCount()
+		st, err := c.AudioZoneStatus(ctx, r)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			h.updatePolled(since, omni.AudioZoneStatusEvent{AudioZoneStatus: s})
+		}
+	}
+	return nil
+}
+
+// refreshSystem polls the controller clock, battery and troubles.
+func (h *Home) refreshSystem(ctx context.Context) error {
+	ss, err := h.client.GetSystemStatus(ctx)
+	if err != nil {
+		return err
+	}
+	st, err := h.client.GetSystemTroubles(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.latestStatus = statusFrom(ss)
+	notify := h.setTroubles(st.Troubles)
+	h.mu.Unlock()
+
+	for _, f := range notify {
+		f()
+	}
+	return nil
+}
+
+// refreshThermostats polls the extended thermostat status, falling back to the basic status on
+// controllers without firmware 3.0, which refuse the extended status request.
+func (h *Home) refreshThermostats(ctx context.Context, r omni.Range) error {
+	since := h.eventCount()
+	ext, err := h.client.ExtendedThermostatStatus(ctx, r)
+	if err == nil {
+		for _, s := range ext {
+			h.updatePolled(since, s)
+		}
+		return nil
+	}
+	if !errors.Is(err, omni.ErrNegativeAck) {
+		return err
+	}
+	since = h.eventCount()
+	st, err := h.client.ThermostatStatus(ctx, r)
+	if err != nil {
+		return err
+	}
+	for _, s := range st {
+		h.updatePolled(since, omni.ThermostatStatusEvent{ThermostatStatus: s})
+	}
+	return nil
+}
+
+func (h *Home) refreshMessages(ctx context.Context) error {
+	r, ok := h.ranges()[omni.Message]
+	if !ok {
+		return nil
+	}
+	since := h.eventCount()
+	st, err := h.client.MessageStatus(ctx, r)
+	if err != nil {
+		return err
+	}
+	for _, s := range st {
+		h.updatePolled(since, omni.MessageStatusEvent{MessageStatus: s})
+	}
+	return nil
+}
+
+// ranges returns the range of object numbers in the Home for each object type with objects.
+func (h *Home) ranges() map[omni.ObjectType]omni.Range {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ranges := map[omni.ObjectType]omni.Range{}
+	add := func(t omni.ObjectType, n int) {
+		r, ok := ranges[t]
+		if !ok {
+			r = omni.Range{Start: n, End: n}
+		}
+		if n < r.Start {
+			r.Start = n
+		}
+		if n > r.End {
+			r.End = n
+		}
+		ranges[t] = r
+	}
+	for n := range h.zones {
+		add(omni.Zone, n)
+	}
+	for n := range h.units {
+		add(omni.Unit, n)
+	}
+	for n := range h.areas {
+		add(omni.Area, n)
+	}
+	for n := range h.thermostats {
+		add(omni.Thermostat, n)
+	}
+	for n := range h.messages {
+		add(omni.Message, n)
+	}
+	for n := range h.audioZones {
+		add(omni.AudioZone, n)
+	}
+	return ranges
+}
+
+// update applies an event to the Home and then calls the change functions for anything it altered.
+// Objects which are not in the Home, because they were not named when it was loaded, are ignored.
+func (h *Home) update(v interface{}) {
+	h.mu.Lock()
+	h.events++
+	if k, ok := keyOf(v); ok {
+		h.changed[k] = h.events
+	}
+	notify := h.apply(v)
+	h.mu.Unlock()
+
+	for _, f := range notify {
+		f()
+	}
+}
+
+// updatePolled applies a polled status like update, unless an event has changed the object since
+// eventCount returned since, in which case the polled status may be older than the Home's.
+func (h *Home) updatePolled(since uint64, v interface{}) {
+	h.mu.Lock()
+	if k, ok := keyOf(v); ok && h.changed[k] > since {
+		h.mu.Unlock()
+		return
+	}
+	notify := h.apply(v)
+	h.mu.Unlock()
+
+	for _, f := range notify {
+		f()
+	}
+}
+
+// eventCount returns the number of events applied so far. It is read before polling the controller, so
+// that updatePolled can tell which objects changed during the poll.
+func (h *Home) eventCount() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.events
+}
+
+// objectKey identifies an object of the Home.
+type objectKey struct {
+	objectType omni.ObjectType
+	number     int
+}
+
+// keyOf returns the object changed by an event or polled status.
+func keyOf(v interface{}) (objectKey, bool) {
+	switch e := v.(type) {
+	case omni.ZoneStatusEvent:
+		return objectKey{omni.Zone, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	case omni.UnitStatusEvent:
+		return objectKey{omni.Unit, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	case omni.AreaStatusEvent:
+		return objectKey{omni.Area, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	case omni.ThermostatStatusEvent:
+		return objectKey{omni.Thermostat, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	case omni.ExtendedThermostatStatus:
+		return objectKey{omni.Thermostat, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	case omni.MessageStatusEvent:
+		return objectKey{omni.Message, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	case omni.AudioZoneStatusEvent:
+		return objectKey{omni.AudioZone, omni.ObjectNumber(e.NumberMSB, e.NumberLSB)}, true
+	}
+	return objectKey{}, false
+}
+
+// apply applies an event or polled status and returns the calls to make to change functions. The caller
+// must hold h.mu.
+func (h *Home) apply(v interface{}) []func() {
+	switch e := v.(type) {
+	case omni.ZoneStatusEvent:
+		if z, ok := h.zones[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			old := *z
+			z.update(e.ZoneStatus)
+			return h.zoneChanged(old, *z)
+		}
+	case omni.UnitStatusEvent:
+		if u, ok := h.units[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			old := *u
+			u.update(e.UnitStatus)
+			return h.unitChanged(old, *u)
+		}
+	case omni.AreaStatusEvent:
+		if a, ok := h.areas[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			old := *a
+			a.update(e.AreaStatus)
+			return h.areaChanged(old, *a)
+		}
+	case omni.ThermostatStatusEvent:
+		if t, ok := h.thermostats[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			old := *t
+			t.update(e.ThermostatStatus)
+			return h.thermostatChanged(old, *t)
+		}
+	case omni.ExtendedThermostatStatus:
+		if t, ok := h.thermostats[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			old := *t
+			t.updateExtended(e)
+			return h.thermostatChanged(old, *t)
+		}
+	case omni.MessageStatusEvent:
+		if m, ok := h.messages[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			m.update(e.MessageStatus)
+		}
+	case omni.AudioZoneStatusEvent:
+		if a, ok := h.audioZones[omni.ObjectNumber(e.NumberMSB, e.NumberLSB)]; ok {
+			a.update(e.AudioZoneStatus)
+		}
+	case omni.TroubleEvent:
+		troubles := []omni.SystemTrouble{}
+		for _, t := range h.troubles {
+			if t != e.Trouble {
+				troubles = append(troubles, t)
+			}
+		}
+		if !e.Cleared {
+			troubles = append(troubles, e.Trouble)
+		}
+		return h.setTroubles(troubles)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package omni
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Range is an inclusive range of object numbers starting at 1.
+type Range struct {
+	Start int
+	End   int
+}
+
+// Typed accessors for the properties of each object type.
+
+// Zones returns the properties of all zones.
+func (c *Client) Zones(ctx context.Context) ([]ZoneProperties, error) {
+	msgs, err := c.objectProperties(ctx, Zone)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]ZoneProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Units returns the properties of all units.
+func (c *Client) Units(ctx context.Context) ([]UnitProperties, error) {
+	msgs, err := c.objectProperties(ctx, Unit)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]UnitProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Buttons returns the properties of all buttons.
+func (c *Client) Buttons(ctx context.Context) ([]ButtonProperties, error) {
+	msgs, err := c.objectProperties(ctx, Button)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]ButtonProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Codes returns the properties of all codes.
+func (c *Client) Codes(ctx context.Context) ([]CodeProperties, error) {
+	msgs, err := c.objectProperties(ctx, Code)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]CodeProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Areas returns the properties of all areas.
+func (c *Client) Areas(ctx context.Context) ([]AreaProperties, error) {
+	msgs, err := c.objectProperties(ctx, Area)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]AreaProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Thermostats returns the properties of all thermostats.
+func (c *Client) Thermostats(ctx context.Context) ([]ThermostatProperties, error) {
+	msgs, err := c.objectProperties(ctx, Thermostat)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]ThermostatProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Messages returns the properties of all messages.
+func (c *Client) Messages(ctx context.Context) ([]MessageProperties, error) {
+	msgs, err := c.objectProperties(ctx, Message)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]MessageProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// AuxilarySensors returns the properties of all auxiliary sensors.
+func (c *Client) AuxilarySensors(ctx context.Context) ([]AuxilarySensorProperties, error) {
+	msgs, err := c.objectProperties(ctx, AuxilarySensor)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]AuxilarySensorProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// AudioSources returns the properties of all audio sources.
+func (c *Client) AudioSources(ctx context.Context) ([]AudioSourceProperties, error) {
+	msgs, err := c.objectProperties(ctx, AudioSource)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]AudioSourceProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// AudioZones returns the properties of all audio zones.
+func (c *Client) AudioZones(ctx context.Context) ([]AudioZoneProperties, error) {
+	msgs, err := c.objectProperties(ctx, AudioZone)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]AudioZoneProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// UserSettings returns the properties of all user settings.
+func (c *Client) UserSettings(ctx context.Context) ([]UserSettingProperties, error) {
+	msgs, err := c.objectProperties(ctx, UserSetting)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]UserSettingProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// AccessControlReaders returns the properties of all access control readers.
+func (c *Client) AccessControlReaders(ctx context.Context) ([]AccessControlReaderProperties, error) {
+	msgs, err := c.objectProperties(ctx, AccessControlReader)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]AccessControlReaderProperties, len(msgs))
+	err = unmarshalMessages(msgs, func(i int) interface{} { return &props[i] })
+	return props, errors.Wrap(err, "Failed to marshal data into Property")
+}
+
+// Typed accessors for the status of each object type.
+
+// ZoneStatus returns the status of zones in the range.
+func (c *Client) ZoneStatus(ctx context.Context, r Range) ([]ZoneStatus, error) {
+	records, err := c.statusRecords(ctx, Zone, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]ZoneStatus, len(records)/StatusSizes[Zone])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// UnitStatus returns the status of units in the range.
+func (c *Client) UnitStatus(ctx context.Context, r Range) ([]UnitStatus, error) {
+	records, err := c.statusRecords(ctx, Unit, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]UnitStatus, len(records)/StatusSizes[Unit])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// AreaStatus returns the status of areas in the range.
+func (c *Client) AreaStatus(ctx context.Context, r Range) ([]AreaStatus, error) {
+	records, err := c.statusRecords(ctx, Area, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]AreaStatus, len(records)/StatusSizes[Area])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// ThermostatStatus returns the status of thermostats in the range.
+func (c *Client) ThermostatStatus(ctx context.Context, r Range) ([]ThermostatStatus, error) {
+	records, err := c.statusRecords(ctx, Thermostat, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]ThermostatStatus, len(records)/StatusSizes[Thermostat])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// MessageStatus returns the status of messages in the range.
+func (c *Client) MessageStatus(ctx context.Context, r Range) ([]MessageStatus, error) {
+	records, err := c.statusRecords(ctx, Message, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]MessageStatus, len(records)/StatusSizes[Message])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// AuxilarySensorStatus returns the status of auxiliary sensors in the range.
+func (c *Client) AuxilarySensorStatus(ctx context.Context, r Range) ([]AuxilarySensorStatus, error) {
+	records, err := c.statusRecords(ctx, AuxilarySensor, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]AuxilarySensorStatus, len(records)/StatusSizes[AuxilarySensor])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// AudioZoneStatus returns the status of audio zones in the range.
+func (c *Client) AudioZoneStatus(ctx context.Context, r Range) ([]AudioZoneStatus, error) {
+	records, err := c.statusRecords(ctx, AudioZone, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]AudioZoneStatus, len(records)/StatusSizes[AudioZone])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// ExpansionEnclosureStatus returns the status of expansion enclosures in the range.
+func (c *Client) ExpansionEnclosureStatus(ctx context.Context, r Range) ([]ExpansionEnclosureStatus, error) {
+	records, err := c.statusRecords(ctx, ExpansionEnclosure, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]ExpansionEnclosureStatus, len(records)/StatusSizes[ExpansionEnclosure])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// UserSettingStatus returns the status of user settings in the range.
+func (c *Client) UserSettingStatus(ctx context.Context, r Range) ([]UserSettingStatus, error) {
+	records, err := c.statusRecords(ctx, UserSetting, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]UserSettingStatus, len(records)/StatusSizes[UserSetting])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// AccessControlReaderStatus returns the status of access control readers in the range.
+func (c *Client) AccessControlReaderStatus(ctx context.Context, r Range) ([]AccessControlReaderStatus, error) {
+	records, err := c.statusRecords(ctx, AccessControlReader, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]AccessControlReaderStatus, len(records)/StatusSizes[AccessControlReader])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// AccessControlLockStatus returns the status of access control reader locks in the range.
+func (c *Client) AccessControlLockStatus(ctx context.Context, r Range) ([]AccessControlLockStatus, error) {
+	records, err := c.statusRecords(ctx, AccessControlLock, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]AccessControlLockStatus, len(records)/StatusSizes[AccessControlLock])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal object status")
+}
+
+// ExtendedThermostatStatus returns the extended status, including humidity and outdoor temperature, of thermostats
+// in the range. Requires controller firmware 3.0 or later.
+func (c *Client) ExtendedThermostatStatus(ctx context.Context, r Range) ([]ExtendedThermostatStatus, error) {
+	records, err := c.extendedStatusRecords(ctx, Thermostat, r)
+	if err != nil {
+		return nil, err
+	}
+	st := make([]ExtendedThermostatStatus, len(records)/ExtendedStatusSizes[Thermostat])
+	err = unmarshalRecords(records, st)
+	return st, errors.Wrap(err, "Failed to unmarshal extended thermostat status")
+}
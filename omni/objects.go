@@ -1,5 +1,7 @@
 package omni
 
+import "bytes"
+
 //go:generate stringer -type=ObjectType
 
 type ObjectType uint8
@@ -24,42 +26,277 @@ const (
 	StatusSizeThermostat = 9
 )
 
+// StatusSizes is the number of bytes in the status record of each object type.
 var StatusSizes = map[ObjectType]int{
-	Zone:       4,
-	Unit:       5,
-	Thermostat: 9,
+	Zone:                4,
+	Unit:                5,
+	Area:                6,
+	Thermostat:          9,
+	Message:             3,
+	AuxilarySensor:      6,
+	AudioZone:           6,
+	ExpansionEnclosure:  4,
+	UserSetting:         5,
+	AccessControlReader: 4,
+	AccessControlLock:   5,
+}
+
+// ExtendedStatusSizes is the number of bytes decoded from each extended status record. Controllers
+// may send longer records in which case the extra bytes are ignored.
+var ExtendedStatusSizes = map[ObjectType]int{
+	Zone:                4,
+	Unit:                5,
+	Area:                6,
+	Thermostat:          14,
+	Message:             3,
+	AuxilarySensor:      6,
+	AudioZone:           6,
+	ExpansionEnclosure:  4,
+	UserSetting:         5,
+	AccessControlReader: 4,
+	AccessControlLock:   5,
 }
 
 // Object and Property messages for each Object type. Structs match the byte layout specified in the protocol
 
+type ZoneProperties struct {
+	ObjectType  uint8
+	NumberMSB   uint8
+	NumberLSB   uint8
+	Status      uint8
+	LoopReading uint8
+	Type        uint8
+	Area        uint8
+	Options     uint8
+	Name        [16]byte
+}
+
+type UnitProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	State      uint8
+	TimeMSB    uint8
+	TimeLSB    uint8
+	Type       uint8
+	Name       [13]byte
+}
+
+type ButtonProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Name       [13]byte
+}
+
+type CodeProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Name       [13]byte
+}
+
+type AreaProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Mode       uint8
+	Alarms     uint8
+	EntryTimer uint8
+	ExitTimer  uint8
+	Enabled    uint8
+	ExitDelay  uint8
+	EntryDelay uint8
+	Name       [13]byte
+}
+
 type ThermostatProperties struct {
 	ObjectType         uint8
 	NumberMSB          uint8
 	NumberLSB          uint8
 	Communicating      uint8
-	Temperature        uint8
-	HeatSetPoint       uint8
-	CoolSetPoint       uint8
+	Temperature        Temperature
+	HeatSetPoint       Temperature
+	CoolSetPoint       Temperature
 	SystemMode         uint8
 	FanMode            uint8
 	HoldStatus         uint8
 	Type               uint8
-	Name               [12]byte
-	Humidty            uint8
-	HumidifySetPoint   uint8
-	DehumidifySetPoint uint8
-	OutdoorTemperature uint8
+	Name               [13]byte
+	Humidty            Humidity
+	HumidifySetPoint   Humidity
+	DehumidifySetPoint Humidity
+	OutdoorTemperature Temperature
 	ActionStatus       uint8
 }
 
+type MessageProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Name       [16]byte
+}
+
+type AuxilarySensorProperties struct {
+	ObjectType   uint8
+	NumberMSB    uint8
+	NumberLSB    uint8
+	OutputStatus uint8
+	Temperature  Temperature // Use Humidity(Temperature) for humidity sensors, type 84
+	LowSetPoint  Temperature
+	HighSetPoint Temperature
+	Type         uint8
+	Name         [16]byte
+}
+
+type AudioSourceProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Name       [13]byte
+}
+
+type AudioZoneProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	On         uint8
+	Source     uint8
+	Volume     uint8
+	Mute       uint8
+	Name       [13]byte
+}
+
+type UserSettingProperties struct {
+	ObjectType uint8
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Type       uint8
+	ValueMSB   uint8
+	ValueLSB   uint8
+	Name       [16]byte
+}
+
+type AccessControlReaderProperties struct {
+	ObjectType     uint8
+	NumberMSB      uint8
+	NumberLSB      uint8
+	Unlocked       uint8
+	UnlockTimerMSB uint8
+	UnlockTimerLSB uint8
+	AccessDenied   uint8
+	LastUser       uint8
+	Name           [16]byte
+}
+
+type ZoneStatus struct {
+	NumberMSB   uint8
+	NumberLSB   uint8
+	Status      uint8
+	LoopReading uint8
+}
+
+type UnitStatus struct {
+	NumberMSB uint8
+	NumberLSB uint8
+	State     uint8
+	TimeMSB   uint8
+	TimeLSB   uint8
+}
+
+type AreaStatus struct {
+	NumberMSB  uint8
+	NumberLSB  uint8
+	Mode       uint8
+	Alarms     uint8
+	EntryTimer uint8
+	ExitTimer  uint8
+}
+
 type ThermostatStatus struct {
 	NumberMSB    uint8
 	NumberLSB    uint8
 	Status       uint8
-	CurrentTemp  uint8
-	HeatSetPoint uint8
-	CoolSetPoint uint8
+	CurrentTemp  Temperature
+	HeatSetPoint Temperature
+	CoolSetPoint Temperature
 	SystemMode   uint8
 	FanMode      uint8
 	HoldStatus   uint8
 }
+
+type ExtendedThermostatStatus struct {
+	ThermostatStatus
+	Humidity           Humidity
+	HumidifySetPoint   Humidity
+	DehumidifySetPoint Humidity
+	OutdoorTemperature Temperature
+	ActionStatus       uint8 // Bits 0-3 are set while heating, cooling, humidifying and dehumidifying
+}
+
+type MessageStatus struct {
+	NumberMSB uint8
+	NumberLSB uint8
+	Status    uint8
+}
+
+type AuxilarySensorStatus struct {
+	NumberMSB    uint8
+	NumberLSB    uint8
+	OutputStatus uint8
+	Temperature  Temperature // Use Humidity(Temperature) for humidity sensors, type 84
+	LowSetPoint  Temperature
+	HighSetPoint Temperature
+}
+
+type AudioZoneStatus struct {
+	NumberMSB uint8
+	NumberLSB uint8
+	On        uint8
+	Source    uint8
+	Volume    uint8
+	Mute      uint8
+}
+
+type ExpansionEnclosureStatus struct {
+	NumberMSB     uint8
+	NumberLSB     uint8
+	Communicating uint8
+	Battery       uint8
+}
+
+type UserSettingStatus struct {
+	NumberMSB uint8
+	NumberLSB uint8
+	Type      uint8
+	ValueMSB  uint8
+	ValueLSB  uint8
+}
+
+type AccessControlReaderStatus struct {
+	NumberMSB    uint8
+	NumberLSB    uint8
+	AccessDenied uint8
+	LastUser     uint8
+}
+
+type AccessControlLockStatus struct {
+	NumberMSB      uint8
+	NumberLSB      uint8
+	Unlocked       uint8
+	UnlockTimerMSB uint8
+	UnlockTimerLSB uint8
+}
+
+// ObjectNumber returns the object number held in the NumberMSB and NumberLSB fields of a record.
+func ObjectNumber(msb, lsb uint8) int {
+	return int(msb)<<8 | int(lsb)
+}
+
+// ObjectName converts a fixed length name field, padded with zeros, to a string.
+func ObjectName(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
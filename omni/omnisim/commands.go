@@ -0,0 +1,129 @@
+package omnisim
+
+import (
+	"encoding/binary"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/proto"
+)
+
+// Zone status bits 4-5 hold the arming status
+const (
+	zoneArmingMask     = 0x30
+	zoneBypassedByUser = 0x20
+)
+
+// command applies a controller command to the installation. Commands for objects that are not
+// configured are refused, and commands which are not simulated are acknowledged without effect.
+func (s *Simulator) command(req *proto.Msg) (*proto.Msg, []*proto.Msg) {
+	if len(req.Data) < 4 {
+		return nak(), nil
+	}
+	cmd := omni.Command(req.Data[0])
+	p1 := req.Data[1]
+	p2 := int(binary.BigEndian.Uint16(req.Data[2:]))
+	in := s.inst
+
+	var t omni.ObjectType
+	var changed []int
+	switch {
+	case cmd == omni.CmdUnitOff || cmd == omni.CmdUnitOn || cmd == omni.CmdUnitLevel:
+		u, ok := in.Units[p2]
+		if !ok {
+			return nak(), nil
+		}
+		u.State = uint8(cmd)
+		if cmd == omni.CmdUnitLevel {
+			u.State = 100 + p1
+		}
+		t, changed = omni.Unit, []int{p2}
+	case cmd == omni.CmdUnitLevelTimed:
+		unit := p2 & 0x1FF
+		u, ok := in.Units[unit]
+		if !ok || !timedLevelDuration(p1) {
+			return nak(), nil
+		}
+		u.State = 100 + uint8(p2>>9)
+		t, changed = omni.Unit, []int{unit}
+	case cmd >= omni.CmdUnitDim && cmd < omni.CmdSecurityMode:
+		if _, ok := in.Units[p2]; !ok {
+			return nak(), nil
+		}
+	case cmd == omni.CmdExecuteButton:
+		if _, ok := in.Buttons[p2]; !ok {
+			return nak(), nil
+		}
+	case cmd >= omni.CmdSecurityMode && cmd <= omni.CmdSecurityMode+omni.Command(omni.NightDelayed):
+		if _, ok := in.Codes[int(p1)]; !ok {
+			return nak(), nil
+		}
+		areas := []int{p2}
+		if p2 == 0 {
+			areas = in.numbers(omni.Area)
+		}
+		for _, n := range areas {
+			a, ok := in.Areas[n]
+			if !ok {
+				return nak(), nil
+			}
+			a.Mode = omni.SecurityMode(cmd - omni.CmdSecurityMode)
+		}
+		t, changed = omni.Area, areas
+	case cmd == omni.CmdBypassZone || cmd == omni.CmdRestoreZone:
+		if _, ok := in.Codes[int(p1)]; !ok {
+			return nak(), nil
+		}
+		z, ok := in.Zones[p2]
+		if !ok {
+			return nak(), nil
+		}
+		z.Status &^= zoneArmingMask
+		if cmd == omni.CmdBypassZone {
+			z.Status |= zoneBypassedByUser
+		}
+		t, changed = omni.Zone, []int{p2}
+	case cmd >= omni.CmdSetHeatSetpoint && cmd <= omni.CmdSetDehumidifySetpoint:
+		thermostats := []int{p2}
+		if p2 == 0 {
+			thermostats = in.numbers(omni.Thermostat)
+		}
+		for _, n := range thermostats {
+			th, ok := in.Thermostats[n]
+			if !ok {
+				return nak(), nil
+			}
+			switch cmd {
+			case omni.CmdSetHeatSetpoint:
+				th.HeatSetPoint = omni.Temperature(p1)
+			case omni.CmdSetCoolSetpoint:
+				th.CoolSetPoint = omni.Temperature(p1)
+			case omni.CmdAdjustHeatSetpoint:
+				th.HeatSetPoint += omni.Temperature(int8(p1))
+			case omni.CmdAdjustCoolSetpoint:
+				th.CoolSetPoint += omni.Temperature(int8(p1))
+			case omni.CmdSetHumidifySetpoint:
+				th.HumidifySetPoint = omni.Humidity(p1)
+			case omni.CmdSetDehumidifySetpoint:
+				th.DehumidifySetPoint = omni.Humidity(p1)
+			case omni.CmdSetThermostatMode:
+				th.SystemMode = omni.ThermostatMode(p1)
+			case omni.CmdSetFanMode:
+				th.FanMode = omni.FanMode(p1)
+			case omni.CmdSetHold:
+				th.Hold = p1 != 0
+			}
+		}
+		t, changed = omni.Thermostat, thermostats
+	}
+
+	if len(changed) == 0 {
+		return ack(), nil
+	}
+	return ack(), []*proto.Msg{s.statusNotification(t, changed)}
+}
+
+// timedLevelDuration reports whether p1 is a duration accepted by the timed unit level command, which
+// is 2-99 seconds, 1-99 minutes or 1-18 hours.
+func timedLevelDuration(p1 uint8) bool {
+	return p1 >= 2 && p1 <= 99 || p1 >= 101 && p1 <= 199 || p1 >= 201 && p1 <= 218
+}
@@ -0,0 +1,57 @@
+package omnisim
+
+import (
+	"github.com/leelynne/omnilink/omni"
+)
+
+// DemoKey is the static key of simulators started by ListenDemo.
+const DemoKey = "00-11-22-33-44-55-66-77-88-99-AA-BB-CC-DD-EE-FF"
+
+// Demo returns a small installation for tests of clients: an Omni IIe on firmware 3.14 using Celsius
+// with three zones, a master code, one area and one thermostat. It has 120 units so that status ranges
+// span several replies. Each call returns a new Installation.
+func Demo() *Installation {
+	inst := &Installation{
+		Info:     omni.SystemInfo{ModelNumber: 30, MajorVersion: 3, MinorVersion: 14, Revision: 2},
+		Status:   omni.SystemStatus{DateValid: 1, Year: 26, Month: 10, Day: 18, Hour: 9, Minute: 30, Battery: 200},
+		Troubles: []omni.SystemTrouble{omni.ACPower},
+		Features: []omni.SystemFeature{omni.HAIHiFi},
+		Formats:  omni.SystemFormats{TempFormat: omni.Celsius, TimeFormat: omni.TwentyFour, DateFormat: omni.DDMM},
+		Zones: map[int]*Zone{
+			1: {Name: "FRONT DOOR", Area: 1, LoopReading: 120},
+			2: {Name: "BACK DOOR", Area: 1, Status: 0x01},
+			3: {Name: "GARAGE", Area: 1},
+		},
+		Units: map[int]*Unit{},
+		Codes: map[int]*Code{
+			1: {Name: "OWNER", Digits: "1234", Authority: omni.Master},
+		},
+		Areas: map[int]*Area{
+			1: {Name: "HOUSE", Enabled: true},
+		},
+		Thermostats: map[int]*Thermostat{
+			1: {
+				Name:               "DOWNSTAIRS",
+				Temperature:        122, // 21°C
+				HeatSetPoint:       120, // 20°C
+				CoolSetPoint:       130, // 25°C
+				SystemMode:         omni.ThermostatAuto,
+				Humidity:           45,
+				OutdoorTemperature: 90, // 5°C
+			},
+		},
+	}
+	for n := 1; n <= 120; n++ {
+		inst.Units[n] = &Unit{Name: "LIGHT", Time: uint16(n)}
+	}
+	return inst
+}
+
+// ListenDemo starts a simulator of inst on a loopback address which accepts clients using DemoKey.
+func ListenDemo(inst *Installation) (*Simulator, error) {
+	key, err := omni.ParseKey(DemoKey)
+	if err != nil {
+		return nil, err
+	}
+	return Listen("127.0.0.1:0", key, inst)
+}
@@ -0,0 +1,642 @@
+package omnisim
+
+import (
+	"sort"
+
+	"github.com/leelynne/omnilink/omni"
+)
+
+// Installation is the in-memory model of the panel served by a Simulator. Objects are keyed by object number.
+type Installation struct {
+	Info     omni.SystemInfo
+	Status   omni.SystemStatus
+	Troubles []omni.SystemTrouble
+	Features []omni.SystemFeature
+	Formats  omni.SystemFormats
+	// Capacities overrides the number of objects of each type reported to clients. Types
+	// not listed report the highest configured object number.
+	Capacities map[omni.ObjectType]int
+	// EventLog is the controller's event log, oldest first.
+	EventLog []omni.LogEntry
+
+	Zones                map[int]*Zone
+	Units                map[int]*Unit
+	Buttons              map[int]*Button
+	Codes                map[int]*Code
+	Areas                map[int]*Area
+	Thermostats          map[int]*Thermostat
+	Messages             map[int]*Message
+	AuxilarySensors      map[int]*AuxilarySensor
+	AudioSources         map[int]*AudioSource
+	AudioZones           map[int]*AudioZone
+	ExpansionEnclosures  map[int]*ExpansionEnclosure
+	UserSettings         map[int]*UserSetting
+	AccessControlReaders map[int]*AccessControlReader
+	AccessControlLocks   map[int]*AccessControlLock
+}
+
+type Zone struct {
+	Name        string
+	Type        uint8
+	Area        uint8
+	Options     uint8
+	Status      uint8 // Bits 0-1 are the current condition, 2-3 the latched alarm and 4-5 the arming status
+	LoopReading uint8
+}
+
+type Unit struct {
+	Name  string
+	Type  uint8
+	State uint8 // 0 is off, 1 is on and 100-200 is a 0-100 percent level
+	Time  uint16
+}
+
+type Button struct {
+	Name string
+}
+
+type Code struct {
+	Name      string
+	Digits    string // Four digit code accepted by security code validation in every area
+	Authority omni.AuthorityLevel
+}
+
+type Area struct {
+	Name       string
+	Mode       omni.SecurityMode
+	Alarms     uint8
+	EntryTimer uint8
+	ExitTimer  uint8
+	Enabled    bool
+	ExitDelay  uint8
+	EntryDelay uint8
+}
+
+type Thermostat struct {
+	Name               string
+	Type               uint8
+	Status             uint8 // Bit 0 is set on a communication failure and bit 1 on a freeze alarm
+	Temperature        omni.Temperature
+	HeatSetPoint       omni.Temperature
+	CoolSetPoint       omni.Temperature
+	SystemMode         omni.ThermostatMode
+	FanMode            omni.FanMode
+	Hold               bool
+	Humidity           omni.Humidity
+	HumidifySetPoint   omni.Humidity
+	DehumidifySetPoint omni.Humidity
+	OutdoorTemperature omni.Temperature
+	ActionStatus       uint8
+}
+
+type Message struct {
+	Name   string
+	Status uint8
+}
+
+type AuxilarySensor struct {
+	Name         string
+	Type         uint8
+	OutputStatus uint8
+	Temperature  omni.Temperature // Temperature or humidity depending on the sensor type
+	LowSetPoint  omni.Temperature
+	HighSetPoint omni.Temperature
+}
+
+type AudioSource struct {
+	Name string
+}
+
+type AudioZone struct {
+	Name   string
+	On     bool
+	Source uint8
+	Volume uint8
+	Mute   bool
+}
+
+type ExpansionEnclosure struct {
+	Communicating bool
+	Battery       uint8
+}
+
+type UserSetting struct {
+	Name  string
+	Type  uint8
+	Value uint16
+}
+
+type AccessControlReader struct {
+	Name         string
+	AccessDenied uint8
+	LastUser     uint8
+}
+
+type AccessControlLock struct {
+	Unlocked    bool
+	UnlockTimer uint16
+}
+
+// object is implemented by each object in the model. properties and status return the structs
+// sent to clients, or nil if the object type has no properties or status.
+type object interface {
+	properties(number int) interface{}
+	status(number int) interface{}
+}
+
+// extendedStatuser is implemented by objects whose extended status differs from their status.
+type extendedStatuser interface {
+	extendedStatus(number int) interface{}
+}
+
+// objects returns the configured objects of a type keyed by object number.
+func (in *Installation) objects(t omni.ObjectType) map[int]object {
+	objs := map[int]object{}
+	switch t {
+	case omni.Zone:
+		for n, o := range in.Zones {
+			objs[n] = o
+		}
+	case omni.Unit:
+		for n, o := range in.Units {
+			objs[n] = o
+		}
+	case omni.Button:
+		for n, o := range in.Buttons {
+			objs[n] = o
+		}
+	case omni.Code:
+		for n, o := range in.Codes {
+			objs[n] = o
+		}
+	case omni.Area:
+		for n, o := range in.Areas {
+			objs[n] = o
+		}
+	case omni.Thermostat:
+		for n, o := range in.Thermostats {
+			objs[n] = o
+		}
+	case omni.Message:
+		for n, o := range in.Messages {
+			objs[n] = o
+		}
+	case omni.AuxilarySensor:
+		for n, o := range in.AuxilarySensors {
+			objs[n] = o
+		}
+	case omni.AudioSource:
+		for n, o := range in.AudioSources {
+			objs[n] = o
+		}
+	case omni.AudioZone:
+		for n, o := range in.AudioZones {
+			objs[n] = o
+		}
+	case omni.ExpansionEnclosure:
+		for n, o := range in.ExpansionEnclosures {
+			objs[n] = o
+		}
+	case omni.UserSetting:
+		for n, o := range in.UserSettings {
+			objs[n] = o
+		}
+	case omni.AccessControlReader:
+		for n, o := range in.AccessControlReaders {
+			objs[n] = &readerWithLock{AccessControlReader: o, lock: in.AccessControlLocks[n]}
+		}
+	case omni.AccessControlLock:
+		for n, o := range in.AccessControlLocks {
+			objs[n] = o
+		}
+	}
+	return objs
+}
+
+// object returns the object of the type with the given number. Unconfigured objects are
+// returned with zero values, as the controller reports them, and nil is returned for unknown types.
+func (in *Installation) object(t omni.ObjectType, number int) object {
+	if o, ok := in.objects(t)[number]; ok {
+		return o
+	}
+	switch t {
+	case omni.Zone:
+		return &Zone{}
+	case omni.Unit:
+		return &Unit{}
+	case omni.Button:
+		return &Button{}
+	case omni.Code:
+		return &Code{}
+	case omni.Area:
+		return &Area{}
+	case omni.Thermostat:
+		return &Thermostat{}
+	case omni.Message:
+		return &Message{}
+	case omni.AuxilarySensor:
+		return &AuxilarySensor{}
+	case omni.AudioSource:
+		return &AudioSource{}
+	case omni.AudioZone:
+		return &AudioZone{}
+	case omni.ExpansionEnclosure:
+		return &ExpansionEnclosure{}
+	case omni.UserSetting:
+		return &UserSetting{}
+	case omni.AccessControlReader:
+		return &readerWithLock{AccessControlReader: &AccessControlReader{}}
+	case omni.AccessControlLock:
+		return &AccessControlLock{}
+	}
+	return nil
+}
+
+// numbers returns the configured object numbers of a type in ascending order.
+func (in *Installation) numbers(t omni.ObjectType) []int {
+	nums := []int{}
+	for n := range in.objects(t) {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+// capacity returns the number of objects of a type reported to clients.
+func (in *Installation) capacity(t omni.ObjectType) int {
+	if c, ok := in.Capacities[t]; ok {
+		return c
+	}
+	nums := in.numbers(t)
+	if len(nums) == 0 {
+		return 0
+	}
+	return nums[len(nums)-1]
+}
+
+func (z *Zone) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.ZoneProperties{
+		ObjectType:  uint8(omni.Zone),
+		NumberMSB:   msb,
+		NumberLSB:   lsb,
+		Status:      z.Status,
+		LoopReading: z.LoopReading,
+		Type:        z.Type,
+		Area:        z.Area,
+		Options:     z.Options,
+		Name:        name16(z.Name),
+	}
+}
+
+func (z *Zone) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.ZoneStatus{
+		NumberMSB:   msb,
+		NumberLSB:   lsb,
+		Status:      z.Status,
+		LoopReading: z.LoopReading,
+	}
+}
+
+func (u *Unit) properties(number int) interface{} {
+	msb, lsb := split(number)
+	tmsb, tlsb := split(int(u.Time))
+	return omni.UnitProperties{
+		ObjectType: uint8(omni.Unit),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		State:      u.State,
+		TimeMSB:    tmsb,
+		TimeLSB:    tlsb,
+		Type:       u.Type,
+		Name:       name13(u.Name),
+	}
+}
+
+func (u *Unit) status(number int) interface{} {
+	msb, lsb := split(number)
+	tmsb, tlsb := split(int(u.Time))
+	return omni.UnitStatus{
+		NumberMSB: msb,
+		NumberLSB: lsb,
+		State:     u.State,
+		TimeMSB:   tmsb,
+		TimeLSB:   tlsb,
+	}
+}
+
+func (b *Button) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.ButtonProperties{
+		ObjectType: uint8(omni.Button),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Name:       name13(b.Name),
+	}
+}
+
+func (b *Button) status(number int) interface{} {
+	return nil
+}
+
+func (c *Code) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.CodeProperties{
+		ObjectType: uint8(omni.Code),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Name:       name13(c.Name),
+	}
+}
+
+func (c *Code) status(number int) interface{} {
+	return nil
+}
+
+func (a *Area) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AreaProperties{
+		ObjectType: uint8(omni.Area),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Mode:       uint8(a.Mode),
+		Alarms:     a.Alarms,
+		EntryTimer: a.EntryTimer,
+		ExitTimer:  a.ExitTimer,
+		Enabled:    boolByte(a.Enabled),
+		ExitDelay:  a.ExitDelay,
+		EntryDelay: a.EntryDelay,
+		Name:       name13(a.Name),
+	}
+}
+
+func (a *Area) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AreaStatus{
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Mode:       uint8(a.Mode),
+		Alarms:     a.Alarms,
+		EntryTimer: a.EntryTimer,
+		ExitTimer:  a.ExitTimer,
+	}
+}
+
+func (t *Thermostat) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.ThermostatProperties{
+		ObjectType:         uint8(omni.Thermostat),
+		NumberMSB:          msb,
+		NumberLSB:          lsb,
+		Communicating:      t.Status,
+		Temperature:        t.Temperature,
+		HeatSetPoint:       t.HeatSetPoint,
+		CoolSetPoint:       t.CoolSetPoint,
+		SystemMode:         uint8(t.SystemMode),
+		FanMode:            uint8(t.FanMode),
+		HoldStatus:         holdByte(t.Hold),
+		Type:               t.Type,
+		Name:               name13(t.Name),
+		Humidty:            t.Humidity,
+		HumidifySetPoint:   t.HumidifySetPoint,
+		DehumidifySetPoint: t.DehumidifySetPoint,
+		OutdoorTemperature: t.OutdoorTemperature,
+		ActionStatus:       t.ActionStatus,
+	}
+}
+
+func (t *Thermostat) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.ThermostatStatus{
+		NumberMSB:    msb,
+		NumberLSB:    lsb,
+		Status:       t.Status,
+		CurrentTemp:  t.Temperature,
+		HeatSetPoint: t.HeatSetPoint,
+		CoolSetPoint: t.CoolSetPoint,
+		SystemMode:   uint8(t.SystemMode),
+		FanMode:      uint8(t.FanMode),
+		HoldStatus:   holdByte(t.Hold),
+	}
+}
+
+func (t *Thermostat) extendedStatus(number int) interface{} {
+	return omni.ExtendedThermostatStatus{
+		ThermostatStatus:   t.status(number).(omni.ThermostatStatus),
+		Humidity:           t.Humidity,
+		HumidifySetPoint:   t.HumidifySetPoint,
+		DehumidifySetPoint: t.DehumidifySetPoint,
+		OutdoorTemperature: t.OutdoorTemperature,
+		ActionStatus:       t.ActionStatus,
+	}
+}
+
+func (m *Message) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.MessageProperties{
+		ObjectType: uint8(omni.Message),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Name:       name16(m.Name),
+	}
+}
+
+func (m *Message) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.MessageStatus{
+		NumberMSB: msb,
+		NumberLSB: lsb,
+		Status:    m.Status,
+	}
+}
+
+func (a *AuxilarySensor) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AuxilarySensorProperties{
+		ObjectType:   uint8(omni.AuxilarySensor),
+		NumberMSB:    msb,
+		NumberLSB:    lsb,
+		OutputStatus: a.OutputStatus,
+		Temperature:  a.Temperature,
+		LowSetPoint:  a.LowSetPoint,
+		HighSetPoint: a.HighSetPoint,
+		Type:         a.Type,
+		Name:         name16(a.Name),
+	}
+}
+
+func (a *AuxilarySensor) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AuxilarySensorStatus{
+		NumberMSB:    msb,
+		NumberLSB:    lsb,
+		OutputStatus: a.OutputStatus,
+		Temperature:  a.Temperature,
+		LowSetPoint:  a.LowSetPoint,
+		HighSetPoint: a.HighSetPoint,
+	}
+}
+
+func (a *AudioSource) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AudioSourceProperties{
+		ObjectType: uint8(omni.AudioSource),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Name:       name13(a.Name),
+	}
+}
+
+func (a *AudioSource) status(number int) interface{} {
+	return nil
+}
+
+func (a *AudioZone) properties(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AudioZoneProperties{
+		ObjectType: uint8(omni.AudioZone),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		On:         boolByte(a.On),
+		Source:     a.Source,
+		Volume:     a.Volume,
+		Mute:       boolByte(a.Mute),
+		Name:       name13(a.Name),
+	}
+}
+
+func (a *AudioZone) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AudioZoneStatus{
+		NumberMSB: msb,
+		NumberLSB: lsb,
+		On:        boolByte(a.On),
+		Source:    a.Source,
+		Volume:    a.Volume,
+		Mute:      boolByte(a.Mute),
+	}
+}
+
+func (e *ExpansionEnclosure) properties(number int) interface{} {
+	return nil
+}
+
+func (e *ExpansionEnclosure) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.ExpansionEnclosureStatus{
+		NumberMSB:     msb,
+		NumberLSB:     lsb,
+		Communicating: boolByte(e.Communicating),
+		Battery:       e.Battery,
+	}
+}
+
+func (u *UserSetting) properties(number int) interface{} {
+	msb, lsb := split(number)
+	vmsb, vlsb := split(int(u.Value))
+	return omni.UserSettingProperties{
+		ObjectType: uint8(omni.UserSetting),
+		NumberMSB:  msb,
+		NumberLSB:  lsb,
+		Type:       u.Type,
+		ValueMSB:   vmsb,
+		ValueLSB:   vlsb,
+		Name:       name16(u.Name),
+	}
+}
+
+func (u *UserSetting) status(number int) interface{} {
+	msb, lsb := split(number)
+	vmsb, vlsb := split(int(u.Value))
+	return omni.UserSettingStatus{
+		NumberMSB: msb,
+		NumberLSB: lsb,
+		Type:      u.Type,
+		ValueMSB:  vmsb,
+		ValueLSB:  vlsb,
+	}
+}
+
+// readerWithLock is an access control reader along with the lock it controls, which has the same object number.
+type readerWithLock struct {
+	*AccessControlReader
+	lock *AccessControlLock
+}
+
+func (r *readerWithLock) properties(number int) interface{} {
+	msb, lsb := split(number)
+	lock := r.lock
+	if lock == nil {
+		lock = &AccessControlLock{}
+	}
+	tmsb, tlsb := split(int(lock.UnlockTimer))
+	return omni.AccessControlReaderProperties{
+		ObjectType:     uint8(omni.AccessControlReader),
+		NumberMSB:      msb,
+		NumberLSB:      lsb,
+		Unlocked:       boolByte(lock.Unlocked),
+		UnlockTimerMSB: tmsb,
+		UnlockTimerLSB: tlsb,
+		AccessDenied:   r.AccessDenied,
+		LastUser:       r.LastUser,
+		Name:           name16(r.Name),
+	}
+}
+
+func (r *readerWithLock) status(number int) interface{} {
+	msb, lsb := split(number)
+	return omni.AccessControlReaderStatus{
+		NumberMSB:    msb,
+		NumberLSB:    lsb,
+		AccessDenied: r.AccessDenied,
+		LastUser:     r.LastUser,
+	}
+}
+
+func (l *AccessControlLock) properties(number int) interface{} {
+	return nil
+}
+
+func (l *AccessControlLock) status(number int) interface{} {
+	msb, lsb := split(number)
+	tmsb, tlsb := split(int(l.UnlockTimer))
+	return omni.AccessControlLockStatus{
+		NumberMSB:      msb,
+		NumberLSB:      lsb,
+		Unlocked:       boolByte(l.Unlocked),
+		UnlockTimerMSB: tmsb,
+		UnlockTimerLSB: tlsb,
+	}
+}
+
+func split(n int) (uint8, uint8) {
+	return uint8(n >> 8), uint8(n)
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// holdByte encodes a thermostat hold status the way the SetHold command does.
+func holdByte(hold bool) uint8 {
+	if hold {
+		return 255
+	}
+	return 0
+}
+
+func name13(s string) [13]byte {
+	var b [13]byte
+	copy(b[:], s)
+	return b
+}
+
+func name16(s string) [16]byte {
+	var b [16]byte
+	copy(b[:], s)
+	return b
+}
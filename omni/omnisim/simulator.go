@@ -0,0 +1,433 @@
+// Package omnisim simulates an Omni-Link II controller so clients can be tested without a panel.
+package omnisim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/proto"
+)
+
+const (
+	// handshakeTimeout bounds the session handshake with each client.
+	handshakeTimeout = 15 * time.Second
+	// maxMsgLength is the largest value of the message length field, which covers the message type and data.
+	maxMsgLength = 255
+)
+
+// Simulator is a controller which serves an Installation to Omni-Link II clients over TCP.
+type Simulator struct {
+	key proto.StaticKey
+	ln  net.Listener
+
+	mu       sync.Mutex
+	inst     *Installation
+	sessions map[*session]struct{}
+	closed   bool
+
+	wg sync.WaitGroup
+}
+
+// session is a connected client.
+type session struct {
+	conn   *proto.ServerConn
+	notify bool // Client has enabled event notifications
+}
+
+// Listen starts a simulator on the TCP address, such as "127.0.0.1:0", which accepts clients using
+// the given key. Clients change the installation with commands.
+func Listen(addr string, key proto.StaticKey, inst *Installation) (*Simulator, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Simulator{
+		key:      key,
+		ln:       ln,
+		inst:     inst,
+		sessions: map[*session]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address clients connect to.
+func (s *Simulator) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the simulator and disconnects every client without ending their sessions.
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.ln.Close()
+	sessions := s.connected(false)
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.conn.Close()
+	}
+
+	s.wg.Wait()
+	return err
+}
+
+// Update calls f with the installation so tests can change it safely while clients are connected.
+// Clients are not notified of the changes, see NotifyStatus.
+func (s *Simulator) Update(f func(inst *Installation)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f(s.inst)
+}
+
+// Terminate ends every client session as the controller does when it restarts.
+func (s *Simulator) Terminate() {
+	s.mu.Lock()
+	sessions := s.connected(false)
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.conn.Terminate()
+	}
+}
+
+// SendEvents sends system event notifications to clients which enabled notifications.
+func (s *Simulator) SendEvents(codes ...uint16) {
+	data := make([]byte, 2*len(codes))
+	for i, code := range codes {
+		binary.BigEndian.PutUint16(data[2*i:], code)
+	}
+	s.mu.Lock()
+	sessions := s.connected(true)
+	s.mu.Unlock()
+
+	notify(sessions, &proto.Msg{Type: proto.MsgSystemEvents, Data: data})
+}
+
+// NotifyStatus sends the current status of the objects to clients which enabled notifications.
+func (s *Simulator) NotifyStatus(t omni.ObjectType, numbers ...int) {
+	s.mu.Lock()
+	m := s.statusNotification(t, numbers)
+	sessions := s.connected(true)
+	s.mu.Unlock()
+
+	if m != nil {
+		notify(sessions, m)
+	}
+}
+
+func (s *Simulator) accept() {
+	defer s.wg.Done()
+	for {
+		nconn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.serve(nconn)
+	}
+}
+
+// serve answers the requests of one client until it disconnects.
+func (s *Simulator) serve(nconn net.Conn) {
+	defer s.wg.Done()
+
+	conn, err := proto.Accept(nconn, s.key, time.Now().Add(handshakeTimeout))
+	if err != nil {
+		nconn.Close()
+		return
+	}
+	sess := &session{conn: conn}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.sessions[sess] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		seq, req, err := conn.ReadRequest()
+		if err != nil {
+			return
+		}
+		// Replies and notifications are sent without holding s.mu so a client which stops reading
+		// only holds up its own session
+		s.mu.Lock()
+		resp, notifications := s.handle(sess, req)
+		sessions := s.connected(true)
+		s.mu.Unlock()
+
+		err = conn.Reply(seq, resp)
+		if err != nil {
+			return
+		}
+		for _, m := range notifications {
+			notify(sessions, m)
+		}
+	}
+}
+
+// connected returns the connected sessions, or only those which enabled notifications. The caller must hold s.mu.
+func (s *Simulator) connected(notifying bool) []*session {
+	sessions := []*session{}
+	for sess := range s.sessions {
+		if sess.notify || !notifying {
+			sessions = append(sessions, sess)
+		}
+	}
+	return sessions
+}
+
+// notify sends an unsolicited message to the sessions. Sessions which cannot be sent to are closed.
+func notify(sessions []*session, m *proto.Msg) {
+	for _, sess := range sessions {
+		err := sess.conn.Notify(m)
+		if err != nil {
+			sess.conn.Close()
+		}
+	}
+}
+
+// handle returns the reply to a request along with any notifications caused by it. The caller must hold s.mu.
+func (s *Simulator) handle(sess *session, req *proto.Msg) (*proto.Msg, []*proto.Msg) {
+	in := s.inst
+	switch req.Type {
+	case proto.MsgReqSystemInfo:
+		return &proto.Msg{Type: proto.MsgSystemInfo, Data: encode(in.Info)}, nil
+	case proto.MsgReqSystemStatus:
+		return &proto.Msg{Type: proto.MsgSystemStatus, Data: encode(in.Status)}, nil
+	case proto.MsgReqSystemTroubles:
+		return &proto.Msg{Type: proto.MsgSystemTroubles, Data: encode(in.Troubles)}, nil
+	case proto.MsgReqSystemFeatures:
+		return &proto.Msg{Type: proto.MsgSystemFeatures, Data: encode(in.Features)}, nil
+	case proto.MsgReqSystemFormats:
+		return &proto.Msg{Type: proto.MsgSystemFormats, Data: encode(in.Formats)}, nil
+	case proto.MsgReqObjectTypeCapacities:
+		if len(req.Data) < 1 {
+			return nak(), nil
+		}
+		t := omni.ObjectType(req.Data[0])
+		msb, lsb := split(in.capacity(t))
+		otc := omni.ObjectTypeCapacities{CapacityType: t, CapacityMSB: msb, CapacityLSB: lsb}
+		return &proto.Msg{Type: proto.MsgObjectTypeCapacities, Data: encode(otc)}, nil
+	case proto.MsgReqObjectProperties:
+		return s.properties(req), nil
+	case proto.MsgReqObjectStatus:
+		return s.status(req, false), nil
+	case proto.MsgReqExtendedObjectStatus:
+		// Extended status requires firmware 3.0 or later
+		if in.Info.MajorVersion < 3 {
+			return nak(), nil
+		}
+		return s.status(req, true), nil
+	case proto.MsgSetTime:
+		if len(req.Data) < 7 {
+			return nak(), nil
+		}
+		d := req.Data
+		in.Status.DateValid, in.Status.Year, in.Status.Month, in.Status.Day = 1, d[0], d[1], d[2]
+		in.Status.DayOfWeek, in.Status.Hour, in.Status.Minute, in.Status.Second = d[3], d[4], d[5], 0
+		in.Status.Daylight = d[6]
+		return ack(), nil
+	case proto.MsgCommand:
+		return s.command(req)
+	case proto.MsgReadEventRecord:
+		return s.eventRecord(req), nil
+	case proto.MsgReqCodeValidation:
+		return s.validateCode(req), nil
+	case proto.MsgEnableNotifications:
+		if len(req.Data) < 1 {
+			return nak(), nil
+		}
+		sess.notify = req.Data[0] != 0
+		return ack(), nil
+	}
+	return nak(), nil
+}
+
+// properties answers an object properties request with the configured object before, at or after the given number.
+func (s *Simulator) properties(req *proto.Msg) *proto.Msg {
+	if len(req.Data) < 4 {
+		return nak()
+	}
+	t := omni.ObjectType(req.Data[0])
+	index := int(req.Data[1])<<8 | int(req.Data[2])
+	relative := int8(req.Data[3])
+
+	nums := s.inst.numbers(t)
+	found := -1
+	switch {
+	case relative > 0:
+		for _, n := range nums {
+			if n > index {
+				found = n
+				break
+			}
+		}
+	case relative < 0:
+		for i := len(nums) - 1; i >= 0; i-- {
+			if nums[i] < index {
+				found = nums[i]
+				break
+			}
+		}
+	default:
+		for _, n := range nums {
+			if n == index {
+				found = n
+			}
+		}
+	}
+	if found < 0 {
+		return &proto.Msg{Type: proto.MsgEndOfData}
+	}
+	props := s.inst.object(t, found).properties(found)
+	if props == nil {
+		return &proto.Msg{Type: proto.MsgEndOfData}
+	}
+	return &proto.Msg{Type: proto.MsgObjectProperties, Data: encode(props)}
+}
+
+// status answers a status or extended status request for a range of objects.
+func (s *Simulator) status(req *proto.Msg, extended bool) *proto.Msg {
+	if len(req.Data) < 5 {
+		return nak()
+	}
+	t := omni.ObjectType(req.Data[0])
+	first := int(req.Data[1])<<8 | int(req.Data[2])
+	last := int(req.Data[3])<<8 | int(req.Data[4])
+	if first < 1 || last < first {
+		return nak()
+	}
+
+	records := &bytes.Buffer{}
+	recordLen := 0
+	for n := first; n <= last; n++ {
+		o := s.inst.object(t, n)
+		if o == nil {
+			return nak()
+		}
+		st := o.status(n)
+		if ext, ok := o.(extendedStatuser); ok && extended {
+			st = ext.extendedStatus(n)
+		}
+		if st == nil {
+			return nak()
+		}
+		record := encode(st)
+		recordLen = len(record)
+		records.Write(record)
+	}
+
+	data := []byte{byte(t)}
+	msgType := proto.MsgObjectStatus
+	if extended {
+		data = append(data, byte(recordLen))
+		msgType = proto.MsgExtendedObjectStatus
+	}
+	data = append(data, records.Bytes()...)
+	if len(data)+1 > maxMsgLength {
+		return nak()
+	}
+	return &proto.Msg{Type: msgType, Data: data}
+}
+
+// eventRecord answers a read event record request with the event at, before or after the given event number.
+func (s *Simulator) eventRecord(req *proto.Msg) *proto.Msg {
+	if len(req.Data) < 3 {
+		return nak()
+	}
+	number := int(req.Data[0])<<8 | int(req.Data[1])
+	relative := int(int8(req.Data[2]))
+	log := s.inst.EventLog
+
+	i := -1
+	switch {
+	case number == 0 && relative > 0:
+		i = 0
+	case number == 0 && relative < 0:
+		i = len(log) - 1
+	case number != 0:
+		for j, e := range log {
+			if e.Number == number {
+				i = j + relative
+			}
+		}
+	}
+	if i < 0 || i >= len(log) {
+		return &proto.Msg{Type: proto.MsgEndOfData}
+	}
+
+	e := log[i]
+	nmsb, nlsb := split(e.Number)
+	p2msb, p2lsb := split(int(e.Param2))
+	data := []byte{nmsb, nlsb, boolByte(e.TimeValid), uint8(e.Month), uint8(e.Day), uint8(e.Hour), uint8(e.Minute),
+		uint8(e.Type), e.Param1, p2msb, p2lsb}
+	return &proto.Msg{Type: proto.MsgEventLogData, Data: data}
+}
+
+// validateCode answers a security code validation request. Codes are valid in every area.
+func (s *Simulator) validateCode(req *proto.Msg) *proto.Msg {
+	if len(req.Data) < 5 {
+		return nak()
+	}
+	digits := make([]byte, 4)
+	for i, d := range req.Data[1:5] {
+		digits[i] = '0' + d
+	}
+	cv := omni.CodeValidation{}
+	for _, n := range s.inst.numbers(omni.Code) {
+		if c := s.inst.Codes[n]; c.Digits != "" && c.Digits == string(digits) {
+			cv = omni.CodeValidation{UserCode: uint8(n), Authority: c.Authority}
+			break
+		}
+	}
+	return &proto.Msg{Type: proto.MsgCodeValidation, Data: encode(cv)}
+}
+
+// statusNotification returns an unsolicited status message for the objects, or nil if the type has no status.
+func (s *Simulator) statusNotification(t omni.ObjectType, numbers []int) *proto.Msg {
+	data := []byte{byte(t)}
+	for _, n := range numbers {
+		o := s.inst.object(t, n)
+		if o == nil {
+			return nil
+		}
+		st := o.status(n)
+		if st == nil {
+			return nil
+		}
+		data = append(data, encode(st)...)
+	}
+	return &proto.Msg{Type: proto.MsgObjectStatus, Data: data}
+}
+
+// encode packs a struct, or slice of them, into the byte layout read by the client.
+func encode(v interface{}) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, v)
+	return buf.Bytes()
+}
+
+func ack() *proto.Msg {
+	return &proto.Msg{Type: proto.MsgAck}
+}
+
+func nak() *proto.Msg {
+	return &proto.Msg{Type: proto.MsgNak}
+}
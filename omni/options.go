@@ -0,0 +1,81 @@
+package omni
+
+import (
+	"io"
+	"time"
+
+	"github.com/leelynne/omnilink/omni/proto"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithReconnect makes the Client establish a new session whenever the connection to the controller
+// is lost, such as when the controller restarts. Attempts are retried with an exponential backoff
+// starting at min and limited to max. Requests made while reconnecting wait for the new session.
+func WithReconnect(min, max time.Duration) Option {
+	if min <= 0 {
+		min = time.Second
+	}
+	if max < min {
+		max = min
+	}
+	return func(c *Client) {
+		c.reconnect = true
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithStateFunc registers a function which is called with every connection state change. The error
+// is the reason the connection was lost, and nil for the other states. The function is called from
+// one goroutine at a time and should return quickly.
+func WithStateFunc(f func(state ConnState, err error)) Option {
+	return func(c *Client) {
+		c.stateFunc = f
+	}
+}
+
+// WithUDP makes the Client talk to the controller over UDP instead of TCP.
+func WithUDP() Option {
+	return func(c *Client) {
+		c.connect = proto.NewUDPConnection
+	}
+}
+
+// WithDialer sets the Dialer used to connect to the controller, such as one which goes through a proxy.
+func WithDialer(d proto.Dialer) Option {
+	return func(c *Client) {
+		c.connOpts = append(c.connOpts, proto.WithDialer(d))
+	}
+}
+
+// Logger receives structured log records from a Client and its connections, see proto.Logger.
+type Logger = proto.Logger
+
+// WithLogger sets the Logger which receives connection and reconnection events, such as a *slog.Logger
+// or a *log.Logger wrapped in proto.StdLogger. Nothing is logged by default.
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		if l != nil {
+			c.logger = l
+			c.connOpts = append(c.connOpts, proto.WithLogger(l))
+		}
+	}
+}
+
+// WithTrace logs every packet exchanged with the controller at debug level to the Logger set by
+// WithLogger. Keys and security codes are never logged.
+func WithTrace() Option {
+	return func(c *Client) {
+		c.connOpts = append(c.connOpts, proto.WithTrace())
+	}
+}
+
+// WithRecorder writes the messages exchanged with the controller to w, as described by proto.Record,
+// so the session can be replayed with proto.NewReplayConn and NewClientFromConn.
+func WithRecorder(w io.Writer) Option {
+	return func(c *Client) {
+		c.connOpts = append(c.connOpts, proto.WithRecorder(w))
+	}
+}
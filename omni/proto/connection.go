@@ -2,10 +2,12 @@ package proto
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -20,8 +22,16 @@ type sessionKey []byte
 //
 // Multiple goroutines may invoke methods on a Conn simultaneously.
 type Conn interface {
-	Read(timeout time.Duration) (*Msg, error)
-	Write(m *Msg, timeout time.Duration) error
+	// Request sends a message to the controller and returns its reply. Replies are matched to requests
+	// so several requests may be in flight at once. Unsolicited messages are never returned by Request.
+	Request(ctx context.Context, m *Msg) (*Msg, error)
+	// Subscribe returns a channel of unsolicited messages pushed by the controller, such as
+	// event notifications, and a function which cancels the subscription.
+	Subscribe() (<-chan *Msg, func())
+	// Done returns a channel which is closed once the connection fails or is closed.
+	Done() <-chan struct{}
+	// Err returns the error which stopped the connection, or nil while it is ok.
+	Err() error
 	Close() error
 }
 
@@ -49,112 +59,322 @@ type conn struct {
 	addr         string
 	err          error
 	closed       bool
+	logger       Logger
+	trace        bool      // Log every packet sent and received
+	recorder     *recorder // Records the application messages sent and received, if set
+
+	pending map[uint16]chan reply // Requests waiting for a reply, keyed by sequence number
+	done    chan struct{}         // Closed when the reader goroutine exits
+
+	// Datagram connections carry one packet per datagram and resend requests until they are answered
+	datagram   bool
+	retransmit time.Duration
+
+	// Serial connections carry unencrypted messages without packet headers, so only one request
+	// is sent at a time and its reply is the next message received of a type which answers it
+	serial       bool
+	turn         chan struct{} // Held by the request in flight on a serial connection
+	awaiting     uint16        // Sequence number of the request in flight on a serial connection
+	awaitingType AppMsgType    // Message type of the request in flight on a serial connection
+
+	subMu sync.Mutex
+	subs  map[chan *Msg]struct{} // Subscribers to unsolicited messages
 }
 
-// NewConnection will create a new connection and session with the controller.
-func NewConnection(addr string, key StaticKey) (Conn, error) {
-	nconn, err := net.DialTimeout("tcp", addr, time.Duration(10*time.Second))
+// reply is a received response to a client request
+type reply struct {
+	msg *Msg
+	err error
+}
+
+const (
+	// handshakeTimeout bounds dialing and creating a session when the caller's context has no deadline.
+	handshakeTimeout = 15 * time.Second
+	// writeTimeout bounds writing a request when the caller's context has no deadline.
+	writeTimeout = 10 * time.Second
+	// subBufferSize is the number of unsolicited messages held for each subscriber before later messages are dropped.
+	subBufferSize = 64
+)
+
+// NewConnection will create a new connection and session with the controller. The context bounds
+// dialing and the session handshake; if it has no deadline, handshakeTimeout is used.
+func NewConnection(ctx context.Context, addr string, key StaticKey, opts ...Option) (Conn, error) {
+	return dial(ctx, "tcp", addr, key, opts)
+}
+
+// NewConnectionFromNetConn creates a session with the controller over an established connection, such as
+// a tunnel or one end of a net.Pipe. Connections implementing net.PacketConn are treated as datagram
+// connections like UDP. The context bounds the session handshake; if it has no deadline, handshakeTimeout
+// is used. The connection is closed if the handshake fails.
+func NewConnectionFromNetConn(ctx context.Context, nconn net.Conn, key StaticKey, opts ...Option) (Conn, error) {
+	o := newOptions(opts)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+		defer cancel()
+	}
+	return newConn(ctx, nconn, nconn.RemoteAddr().String(), key, o)
+}
+
+// dial connects to the controller over the network and creates a session.
+func dial(ctx context.Context, network, addr string, key StaticKey, opts []Option) (*conn, error) {
+	o := newOptions(opts)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+		defer cancel()
+	}
+
+	nconn, err := o.dialer.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, ConnError{Op: "dial", Addr: addr, Err: err}
 	}
+	return newConn(ctx, nconn, addr, key, o)
+}
 
+// newConn creates a session over nconn, which is closed if the handshake fails.
+func newConn(ctx context.Context, nconn net.Conn, addr string, key StaticKey, o *options) (*conn, error) {
 	oconn := &conn{
-		addr:   addr,
-		nconn:  nconn,
-		seqNum: 1,
+		addr:     addr,
+		nconn:    nconn,
+		logger:   o.logger,
+		trace:    o.trace,
+		recorder: o.recorder,
+		seqNum:   1,
+		pending:  map[uint16]chan reply{},
+		done:     make(chan struct{}),
+		subs:     map[chan *Msg]struct{}{},
+	}
+	if _, ok := nconn.(net.PacketConn); ok {
+		oconn.datagram = true
+		oconn.retransmit = retransmitInterval
+	}
+	deadline, _ := ctx.Deadline()
+	stop := interruptOnCancel(ctx, nconn)
+	err := oconn.handshake(deadline, key)
+	if cerr := stop(); cerr != nil {
+		err = ConnError{Op: "handshake", Addr: addr, Err: cerr}
+	}
+	if err != nil {
+		nconn.Close()
+		oconn.logger.Warn("Session handshake failed", "addr", addr, "err", err)
+		return nil, err
+	}
+	// Clear the handshake deadlines
+	nconn.SetDeadline(time.Time{})
+	oconn.logger.Info("Session established", "addr", addr, "proto", oconn.protoVersion)
+
+	go oconn.readLoop()
+	return oconn, nil
+}
+
+// interruptOnCancel unblocks pending network calls on nconn when ctx is cancelled. The returned
+// function stops watching ctx and returns its error, if any.
+func interruptOnCancel(ctx context.Context, nconn net.Conn) func() error {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			nconn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() error {
+		close(stop)
+		<-stopped
+		return ctx.Err()
 	}
+}
 
+// handshake establishes a new secure session with the controller.
+func (c *conn) handshake(timeout time.Time, key StaticKey) error {
 	// New Session
 	newp := &packet{
-		seqNum:  oconn.nextSeqNum(),
+		seqNum:  c.nextSeqNum(),
 		msgType: msgClientReqNewSession,
 	}
-	timeout := time.Now().Add(time.Second * 15)
-	err = oconn.sendPacket(newp, timeout)
+	ackSessionp, err := c.exchange(newp, timeout)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	ackSessionp, err := oconn.recvPacket(timeout)
-	if err != nil {
-		return nil, err
-	}
-	if ackSessionp.msgType != msgControllerAckNewSession {
-		return nil, fmt.Errorf("Could not establish new session with controller")
+	switch ackSessionp.msgType {
+	case msgControllerAckNewSession:
+	case msgControllerCannotStartNewSession:
+		return ErrSessionRejected
+	default:
+		return errors.Wrapf(ErrUnexpectedReply, "Packet type %d to new session request", ackSessionp.msgType)
 	}
 	as := ackNewSession{}
 	err = ackSessionp.unmarshal(&as)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	oconn.protoVersion = as.ProtoVersion
-	oconn.sessionKey, err = createSessionKey(key, as.SessionID[:])
+	c.protoVersion = as.ProtoVersion
+	c.sessionKey, err = createSessionKey(key, as.SessionID[:])
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create session key - %s", err)
+		return fmt.Errorf("Failed to create session key - %s", err)
 	}
 
-	oconn.cipher, err = aes.NewCipher(oconn.sessionKey[:])
+	c.cipher, err = aes.NewCipher(c.sessionKey[:])
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create client cipher - %s", err.Error())
+		return fmt.Errorf("Failed to create client cipher - %s", err.Error())
 	}
-	fmt.Printf("Proto: %d\n", as.ProtoVersion)
 
 	// Secure connection
 	secp := &packet{
-		seqNum:  oconn.nextSeqNum(),
+		seqNum:  c.nextSeqNum(),
 		msgType: msgClientReqSecureConnection,
 		data:    as.SessionID[:],
 	}
-	err = oconn.sendPacket(secp, timeout)
+	ackSecurep, err := c.exchange(secp, timeout)
 	if err != nil {
-		return nil, err
-	}
-
-	ackSecurep, err := oconn.recvPacket(timeout)
-	if err != nil {
-		return nil, err
+		return err
 	}
 	if ackSecurep.msgType != msgControllerAckSecureConnection {
-		return nil, fmt.Errorf("Client generated wrong session key")
+		// The controller ends the session when it cannot decrypt the request
+		return ErrBadKey
 	}
 	sec := ackSecureSession{}
 	err = ackSecurep.unmarshal(&sec)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if sec.SessionID != as.SessionID {
-		return nil, fmt.Errorf("Failed to match session id on secure connection.")
+		return errors.Wrap(ErrBadKey, "Failed to match session id on secure connection")
 	}
 
-	return oconn, err
+	return nil
+}
+
+func (c *conn) Request(ctx context.Context, m *Msg) (*Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ConnError{Op: "write", Addr: c.addr, Err: err}
+	}
+	if c.serial {
+		select {
+		case c.turn <- struct{}{}:
+			defer func() { <-c.turn }()
+		case <-c.done:
+			return nil, errors.Wrap(c.Err(), "Connection not ok")
+		case <-ctx.Done():
+			return nil, ConnError{Op: "write", Addr: c.addr, Err: ctx.Err()}
+		}
+	}
+
+	replies := make(chan reply, 1)
+	p, err := c.send(ctx, m, replies)
+	if err != nil {
+		return nil, err
+	}
+	defer c.forget(p.seqNum)
+
+	// Datagram connections resend the request until the reply arrives
+	var resend <-chan time.Time
+	if c.retransmit > 0 {
+		t := time.NewTicker(c.retransmit)
+		defer t.Stop()
+		resend = t.C
+	}
+	for retransmits := 0; ; {
+		select {
+		case r := <-replies:
+			if r.err != nil {
+				return nil, errors.Wrap(r.err, "Failed to receive packet")
+			}
+			return r.msg, nil
+		case <-c.done:
+			return nil, errors.Wrap(c.Err(), "Connection not ok")
+		case <-ctx.Done():
+			return nil, ConnError{Op: "read", Addr: c.addr, Err: ctx.Err()}
+		case <-resend:
+			if retransmits == maxRetransmits {
+				return nil, errors.Wrapf(ErrTimeout, "No reply after %d retransmits", maxRetransmits)
+			}
+			retransmits++
+			c.mu.Lock()
+			if c.ok() {
+				err := c.sendPacket(p, time.Now().Add(c.retransmit))
+				if err != nil {
+					c.abort(err)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
 }
 
-func (c *conn) Read(timeout time.Duration) (*Msg, error) {
+// send writes a request to the controller after registering replies to receive its reply.
+func (c *conn) send(ctx context.Context, m *Msg, replies chan reply) (*packet, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if !c.ok() {
+		return nil, c.err
+	}
+	if c.err != nil {
 		return nil, errors.Wrap(c.err, "Connection not ok")
 	}
 
-	p, err := c.recvPacket(time.Now().Add(timeout))
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(writeTimeout)
+	}
+	p := m.packet(c.nextSeqNum())
+	c.pending[p.seqNum] = replies
+	err := c.sendPacket(p, deadline)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to receive packet")
+		delete(c.pending, p.seqNum)
+		c.abort(err)
+		return nil, err
 	}
-
-	return NewMsg(p)
+	if c.serial {
+		c.awaiting = p.seqNum
+		c.awaitingType = m.Type
+	}
+	c.recordMsg(true, p.seqNum, m)
+	return p, nil
 }
 
-func (c *conn) Write(m *Msg, timeout time.Duration) error {
+// forget stops waiting for the reply to a request. Replies which arrive later are discarded.
+func (c *conn) forget(seqNum uint16) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if !c.ok() {
-		return c.err
+	delete(c.pending, seqNum)
+	if c.awaiting == seqNum {
+		c.awaiting = 0
 	}
+}
 
-	return c.sendPacket(m.packet(c.nextSeqNum()), time.Now().Add(timeout))
+// Subscribe registers for unsolicited messages from the controller. Messages are dropped when the
+// subscriber falls more than subBufferSize messages behind. The channel is closed when the returned
+// cancel function is called or the connection fails.
+func (c *conn) Subscribe() (<-chan *Msg, func()) {
+	ch := make(chan *Msg, subBufferSize)
+	c.subMu.Lock()
+	select {
+	case <-c.done:
+		close(ch)
+	default:
+		c.subs[ch] = struct{}{}
+	}
+	c.subMu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.subMu.Lock()
+			defer c.subMu.Unlock()
+			if _, ok := c.subs[ch]; ok {
+				delete(c.subs, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, cancel
 }
 
 // Close will close the connection. Close can be called multiple times.
@@ -166,9 +386,15 @@ func (c *conn) Close() error {
 		return nil
 	}
 	c.closed = true
-	if c.err == nil {
-		c.err = fmt.Errorf("Connection is closed.")
+	if c.err != nil {
+		// The connection was closed when it failed
+		return nil
+	}
+	if c.serial {
+		// Serial sessions are not ended by closing the port
+		c.sendPacket((&Msg{Type: msgLogout}).packet(0), time.Now().Add(time.Second))
 	}
+	c.err = ErrClosed
 	neterr := c.nconn.Close()
 	if neterr != nil {
 		c.err = neterr
@@ -176,6 +402,111 @@ func (c *conn) Close() error {
 	return neterr
 }
 
+// readLoop receives every packet sent by the controller. Replies are handed to the request with the same
+// sequence number, and unsolicited messages, which the controller sends without sequence tracking, go to subscribers.
+func (c *conn) readLoop() {
+	defer c.closeSubs()
+	defer close(c.done)
+
+	for {
+		p, err := c.recvPacket(time.Time{})
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch p.msgType {
+		case msgControllerSessionTerminated:
+			c.logger.Info("Session terminated by controller", "addr", c.addr)
+			c.fail(ErrSessionTerminated)
+			return
+		case msgAppData:
+		default:
+			continue
+		}
+
+		m, err := NewMsg(p)
+		if err == nil {
+			c.recordMsg(false, p.seqNum, m)
+		}
+		if p.seqNum == 0 {
+			if err == nil {
+				c.publish(m)
+			}
+			continue
+		}
+		c.deliver(p.seqNum, reply{msg: m, err: err})
+	}
+}
+
+// deliver hands a reply to the request waiting for it. Replies to requests which gave up waiting, and
+// duplicate replies to resent requests, are discarded.
+func (c *conn) deliver(seqNum uint16, r reply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replies, ok := c.pending[seqNum]
+	if !ok {
+		return
+	}
+	delete(c.pending, seqNum)
+	replies <- r
+}
+
+// publish sends an unsolicited message to every subscriber.
+func (c *conn) publish(m *Msg) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for ch := range c.subs {
+		select {
+		case ch <- m:
+		default:
+			// Subscriber is not keeping up
+		}
+	}
+}
+
+func (c *conn) closeSubs() {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for ch := range c.subs {
+		delete(c.subs, ch)
+		close(ch)
+	}
+}
+
+// fail records the error which stopped the connection and closes it.
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.abort(err)
+}
+
+// abort records the error which stopped the connection and closes it, which also stops the read loop
+// after a write error. The caller must hold c.mu.
+func (c *conn) abort(err error) {
+	if c.err != nil {
+		// Already failed or closed
+		return
+	}
+	c.err = err
+	c.logger.Warn("Connection failed", "addr", c.addr, "err", err)
+	c.nconn.Close()
+}
+
+func (c *conn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
 func (c *conn) ok() bool {
 	if c.closed {
 		return false
@@ -194,6 +525,9 @@ func (c *conn) nextSeqNum() uint16 {
 
 func (c *conn) sendPacket(p *packet, timeout time.Time) error {
 	b := p.serialize(c.cipher)
+	if c.serial {
+		b = p.data
+	}
 	c.nconn.SetWriteDeadline(timeout)
 	for written := 0; written < len(b); {
 		n, err := c.nconn.Write(b[written:])
@@ -202,11 +536,37 @@ func (c *conn) sendPacket(p *packet, timeout time.Time) error {
 		}
 		written += n
 	}
+	c.tracePacket("send", p)
 	return nil
 }
 
+// recvPacket reads the next packet, decrypting its data.
 func (c *conn) recvPacket(timeout time.Time) (*packet, error) {
-	header, err := c.getBytes(4, timeout)
+	p, err := c.readPacket(timeout)
+	if err != nil {
+		return p, err
+	}
+	c.tracePacket("recv", p)
+	return p, nil
+}
+
+func (c *conn) readPacket(timeout time.Time) (*packet, error) {
+	c.nconn.SetReadDeadline(timeout)
+	if c.serial {
+		return c.recvSerial()
+	}
+	var r io.Reader = c.nconn
+	if c.datagram {
+		// Each datagram holds exactly one packet
+		buf := make([]byte, maxDatagramSize)
+		n, err := c.nconn.Read(buf)
+		if err != nil {
+			return nil, ConnError{Op: "read", Addr: c.addr, Err: err}
+		}
+		r = bytes.NewReader(buf[:n])
+	}
+
+	header, err := c.getBytes(r, 4)
 	if err != nil {
 		return nil, err
 	}
@@ -229,27 +589,28 @@ func (c *conn) recvPacket(timeout time.Time) (*packet, error) {
 	encrypted := false
 	switch p.msgType {
 	case msgControllerCannotStartNewSession, msgControllerSessionTerminated:
+	case msgClientReqNewSession, msgClientSessionTerminated:
 	case msgControllerAckNewSession:
 		dataLen = 7
-	case msgControllerAckSecureConnection:
+	case msgControllerAckSecureConnection, msgClientReqSecureConnection:
 		encrypted = true
 		dataLen = 5 + padLength(5)
 	case msgAppData:
 		encrypted = true
 		// Read the first block of the encrypted data in order to get size of the message
-		p.data, err = c.getBytes(blockSize, timeout)
+		p.data, err = c.getBytes(r, blockSize)
 		if err != nil {
 			return p, err
 		}
 		msgHeader := p.decrypt(c.cipher)
 		// Figure out the total length of the encrypted message
-		unpaddedLength := int(msgHeader[1])                        // Length of unpadded/unencrypted data section
-		totalLength := unpaddedLength + 5                          // start char, length, type, and crc fields
+		unpaddedLength := int(msgHeader[1])                        // Length of the type and data fields
+		totalLength := unpaddedLength + 4                          // Plus the start char, length and crc fields
 		dataLen = totalLength + padLength(totalLength) - blockSize // Subtract what was already read
 	default:
 		return p, fmt.Errorf("Unknown message type %d", p.msgType)
 	}
-	data, err := c.getBytes(dataLen, timeout)
+	data, err := c.getBytes(r, dataLen)
 	if err != nil {
 		return p, err
 	}
@@ -260,20 +621,15 @@ func (c *conn) recvPacket(timeout time.Time) (*packet, error) {
 	return p, nil
 }
 
-// getBytes reads the specified number of bytes from the underlying connection
-func (c *conn) getBytes(numBytes int, timeout time.Time) ([]byte, error) {
+// getBytes reads the specified number of bytes of a packet
+func (c *conn) getBytes(r io.Reader, numBytes int) ([]byte, error) {
 	if numBytes <= 0 {
 		return []byte{}, nil
 	}
 	buf := make([]byte, numBytes)
-	c.nconn.SetReadDeadline(timeout)
-	read := 0
-	for read < numBytes {
-		n, err := c.nconn.Read(buf)
-		if err != nil {
-			return nil, ConnError{Op: "read", Addr: c.addr, Err: err}
-		}
-		read += n
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, ConnError{Op: "read", Addr: c.addr, Err: err}
 	}
 	return buf, nil
 }
@@ -0,0 +1,194 @@
+package proto
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var testKey = StaticKey{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10}
+
+// newTestSession creates a session over a net.Pipe, returning the client and controller ends of it.
+// Both ends are closed by the returned function.
+func newTestSession(t *testing.T) (Conn, *ServerConn, func()) {
+	t.Helper()
+	cconn, sconn := net.Pipe()
+	accepted := make(chan *ServerConn, 1)
+	go func() {
+		s, err := Accept(sconn, testKey, time.Now().Add(5*time.Second))
+		if err != nil {
+			t.Errorf("Accept failed: %s", err)
+			sconn.Close()
+		}
+		accepted <- s
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := NewConnectionFromNetConn(ctx, cconn, testKey)
+	if err != nil {
+		t.Fatalf("NewConnectionFromNetConn failed: %s", err)
+	}
+	s := <-accepted
+	if s == nil {
+		t.FailNow()
+	}
+	return c, s, func() {
+		c.Close()
+		s.Close()
+	}
+}
+
+// TestBlockSizedMessages sends messages whose start character, length, type, data and CRC fill whole AES
+// blocks, as Event Log Data replies do. Reading one more block than the message holds stalls the session
+// until the next message arrives.
+func TestBlockSizedMessages(t *testing.T) {
+	c, s, done := newTestSession(t)
+	defer done()
+
+	// Messages of one, one, two and two blocks
+	msg := func(i int) *Msg {
+		data := make([]byte, (i/2+1)*blockSize-5)
+		data[0] = byte(i)
+		return &Msg{Type: MsgSystemStatus, Data: data}
+	}
+	if n := len(msg(0).serialize()); n != blockSize {
+		t.Fatalf("Message is %d bytes, want %d", n, blockSize)
+	}
+	go func() {
+		for i := 0; ; i++ {
+			seqNum, req, err := s.ReadRequest()
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(req.Data, msg(i).Data) {
+				t.Errorf("Request %d has data %v", i, req.Data)
+			}
+			s.Reply(seqNum, msg(i))
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		resp, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus, Data: msg(i).Data})
+		cancel()
+		if err != nil {
+			t.Fatalf("Request %d failed: %s", i, err)
+		}
+		if resp.Type != MsgSystemStatus || !bytes.Equal(resp.Data, msg(i).Data) {
+			t.Fatalf("Request %d received %s %v", i, resp.Type, resp.Data)
+		}
+	}
+}
+
+// TestLateReply answers a request after the caller stopped waiting for it. The late reply must be
+// discarded rather than returned to the next request.
+func TestLateReply(t *testing.T) {
+	c, s, done := newTestSession(t)
+	defer done()
+
+	go func() {
+		late, _, err := s.ReadRequest()
+		if err != nil {
+			return
+		}
+		seqNum, req, err := s.ReadRequest()
+		if err != nil {
+			return
+		}
+		s.Reply(late, &Msg{Type: MsgSystemStatus, Data: []byte{1}})
+		s.Reply(seqNum, &Msg{Type: MsgSystemStatus, Data: req.Data})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus, Data: []byte{1}})
+	cancel()
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Request returned %v, want %s", err, ErrTimeout)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus, Data: []byte{2}})
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if !bytes.Equal(resp.Data, []byte{2}) {
+		t.Fatalf("Request received reply %v, want %v", resp.Data, []byte{2})
+	}
+}
+
+// TestConcurrentRequests answers requests in the reverse of the order they were sent. Each must
+// receive its own reply.
+func TestConcurrentRequests(t *testing.T) {
+	const n = 20
+	c, s, done := newTestSession(t)
+	defer done()
+
+	go func() {
+		var seqNums []uint16
+		var reqs []*Msg
+		for len(reqs) < n {
+			seqNum, req, err := s.ReadRequest()
+			if err != nil {
+				return
+			}
+			seqNums = append(seqNums, seqNum)
+			reqs = append(reqs, req)
+		}
+		for i := n - 1; i >= 0; i-- {
+			s.Reply(seqNums[i], &Msg{Type: MsgSystemStatus, Data: reqs[i].Data})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i byte) {
+			defer wg.Done()
+			resp, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus, Data: []byte{i}})
+			if err != nil {
+				t.Errorf("Request %d failed: %s", i, err)
+				return
+			}
+			if !bytes.Equal(resp.Data, []byte{i}) {
+				t.Errorf("Request %d received reply %v", i, resp.Data)
+			}
+		}(byte(i))
+	}
+	wg.Wait()
+}
+
+// TestWriteTimeout checks that a request which cannot be written before its deadline ends the session,
+// as part of the packet may have been written.
+func TestWriteTimeout(t *testing.T) {
+	c, _, done := newTestSession(t)
+	defer done()
+
+	// The controller end is not reading, so the write blocks
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus})
+	if err == nil {
+		t.Fatal("Request succeeded without the controller reading it")
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection still open after a failed write")
+	}
+	if c.Err() == nil {
+		t.Error("Connection failed without an error")
+	}
+	_, err = c.Request(context.Background(), &Msg{Type: MsgReqSystemStatus})
+	if err == nil {
+		t.Error("Request succeeded after a failed write")
+	}
+}
@@ -0,0 +1,62 @@
+package proto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by connections can be matched with errors.Is. ErrTimeout and ErrSessionTerminated
+// are usually transient and a new connection may succeed, while ErrBadKey and ErrSessionRejected will
+// recur until the key or code is corrected or, for ErrSessionRejected, another client disconnects.
+var (
+	// ErrBadKey is returned when the controller does not accept the session key derived from the
+	// static key, which happens when the static key is wrong.
+	ErrBadKey = errors.New("Controller rejected the session key")
+	// ErrSessionRejected is returned when the controller cannot start a new session, such as when it
+	// is busy with other clients, or rejects the login code of a serial connection.
+	ErrSessionRejected = errors.New("Controller could not start a new session")
+	// ErrSessionTerminated is returned once the controller ends the session.
+	ErrSessionTerminated = errors.New("Session terminated by controller")
+	// ErrClosed is returned by connections which have been closed.
+	ErrClosed = errors.New("Connection is closed")
+	// ErrCRC is returned when a received message fails its CRC check.
+	ErrCRC = errors.New("CRC mismatch on received packet")
+	// ErrTimeout is matched by errors caused by a deadline passing before the controller replied,
+	// either a network timeout or the expiry of a request's context.
+	ErrTimeout = errors.New("Timed out waiting for controller")
+	// ErrUnexpectedReply is matched by errors returned when the controller answers with a message
+	// of the wrong type.
+	ErrUnexpectedReply = errors.New("Unexpected reply from controller")
+)
+
+// UnexpectedReplyError is returned when the controller answers a request with a message of the wrong type.
+type UnexpectedReplyError struct {
+	Request AppMsgType
+	Reply   AppMsgType
+}
+
+func (e UnexpectedReplyError) Error() string {
+	return fmt.Sprintf("Unexpected reply %s to request %s", e.Reply, e.Request)
+}
+
+// Is reports whether target is ErrUnexpectedReply.
+func (e UnexpectedReplyError) Is(target error) bool {
+	return target == ErrUnexpectedReply
+}
+
+// Unwrap returns the error that occurred during the operation.
+func (ce ConnError) Unwrap() error {
+	return ce.Err
+}
+
+// Is reports whether target is ErrTimeout and the operation timed out.
+func (ce ConnError) Is(target error) bool {
+	return target == ErrTimeout && ce.Timeout()
+}
+
+// Timeout reports whether the operation failed because a deadline passed.
+func (ce ConnError) Timeout() bool {
+	return ce.Err == context.DeadlineExceeded || isTimeout(ce.Err)
+}
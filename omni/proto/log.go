@@ -0,0 +1,128 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives structured log records. The arguments after the message are alternating keys and
+// values, as with log/slog, and *slog.Logger satisfies Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards all records. It is the default Logger.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Warn(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// StdLogger adapts a *log.Logger to Logger. Records are written on one line as the level, message and
+// key=value pairs.
+type StdLogger struct {
+	*log.Logger
+}
+
+func (l StdLogger) Debug(msg string, args ...interface{}) { l.output("DEBUG", msg, args) }
+func (l StdLogger) Info(msg string, args ...interface{})  { l.output("INFO", msg, args) }
+func (l StdLogger) Warn(msg string, args ...interface{})  { l.output("WARN", msg, args) }
+func (l StdLogger) Error(msg string, args ...interface{}) { l.output("ERROR", msg, args) }
+
+func (l StdLogger) output(level, msg string, args []interface{}) {
+	buf := bytes.Buffer{}
+	buf.WriteString(level)
+	buf.WriteString(" ")
+	buf.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&buf, " !BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&buf, " %v=%v", args[i], args[i+1])
+	}
+	l.Output(3, buf.String())
+}
+
+// maxTraceData is the number of data bytes included in the trace of messages which are not decoded.
+const maxTraceData = 32
+
+var packetTypeNames = map[msgType]string{
+	msgClientReqNewSession:             "ClientRequestNewSession",
+	msgControllerAckNewSession:         "ControllerAckNewSession",
+	msgClientReqSecureConnection:       "ClientRequestSecureConnection",
+	msgControllerAckSecureConnection:   "ControllerAckSecureConnection",
+	msgClientSessionTerminated:         "ClientSessionTerminated",
+	msgControllerSessionTerminated:     "ControllerSessionTerminated",
+	msgControllerCannotStartNewSession: "ControllerCannotStartNewSession",
+	msgAppData:                         "OmniLink2Message",
+}
+
+// tracePacket logs a packet sent or received when tracing is enabled. The data of handshake packets,
+// which holds the session id the session key is derived from, is never logged, and neither is the
+// data of messages carrying security codes.
+func (c *conn) tracePacket(dir string, p *packet) {
+	if !c.trace {
+		return
+	}
+	name, ok := packetTypeNames[p.msgType]
+	if !ok {
+		name = fmt.Sprintf("Unknown(%d)", p.msgType)
+	}
+	args := []interface{}{"dir", dir, "addr", c.addr, "seq", p.seqNum, "packet", name}
+	if p.msgType != msgAppData {
+		c.logger.Debug("Packet", args...)
+		return
+	}
+
+	m, err := NewMsg(p)
+	if err != nil {
+		args = append(args, "err", err)
+		c.logger.Debug("Packet", args...)
+		return
+	}
+	args = append(args, "msg", m.Type, "len", len(m.Data))
+	args = append(args, traceFields(m)...)
+	c.logger.Debug("Packet", args...)
+}
+
+// traceFields returns the fields of a message to include in a packet trace. Object requests, commands
+// and system events are decoded, and the data of other messages is logged in hex.
+func traceFields(m *Msg) []interface{} {
+	d := m.Data
+	switch m.Type {
+	case msgLogin, MsgReqCodeValidation:
+		return []interface{}{"data", "redacted"}
+	case MsgReqObjectProperties:
+		if len(d) >= 7 {
+			return []interface{}{"objtype", d[0], "number", binary.BigEndian.Uint16(d[1:3]),
+				"direction", int8(d[3]), "filters", fmt.Sprintf("%d,%d,%d", d[4], d[5], d[6])}
+		}
+	case MsgReqObjectStatus, MsgReqExtendedObjectStatus:
+		if len(d) >= 5 {
+			return []interface{}{"objtype", d[0], "start", binary.BigEndian.Uint16(d[1:3]),
+				"end", binary.BigEndian.Uint16(d[3:5])}
+		}
+	case MsgCommand:
+		if len(d) >= 4 {
+			return []interface{}{"cmd", d[0], "p1", d[1], "p2", binary.BigEndian.Uint16(d[2:4])}
+		}
+	case MsgSystemEvents:
+		codes := make([]string, 0, len(d)/2)
+		for i := 0; i+1 < len(d); i += 2 {
+			codes = append(codes, fmt.Sprintf("%#04x", binary.BigEndian.Uint16(d[i:i+2])))
+		}
+		return []interface{}{"events", strings.Join(codes, ",")}
+	}
+	if len(d) > maxTraceData {
+		d = d[:maxTraceData]
+	}
+	return []interface{}{"data", fmt.Sprintf("%x", d)}
+}
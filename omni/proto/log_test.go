@@ -0,0 +1,28 @@
+package proto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTraceFields(t *testing.T) {
+	tests := []struct {
+		msg  *Msg
+		want string
+	}{
+		{&Msg{Type: MsgReqObjectStatus, Data: []byte{0x02, 0x00, 0x01, 0x01, 0x00}}, "[objtype 2 start 1 end 256]"},
+		{&Msg{Type: MsgReqExtendedObjectStatus, Data: []byte{0x06, 0x00, 0x01, 0x00, 0x02}}, "[objtype 6 start 1 end 2]"},
+		{&Msg{Type: MsgReqObjectProperties, Data: []byte{0x01, 0x00, 0x05, 0x01, 0x01, 0x00, 0x00}}, "[objtype 1 number 5 direction 1 filters 1,0,0]"},
+		{&Msg{Type: MsgCommand, Data: []byte{0x09, 0x32, 0x00, 0x07}}, "[cmd 9 p1 50 p2 7]"},
+		{&Msg{Type: MsgSystemEvents, Data: []byte{0x08, 0x01, 0x03, 0x02}}, "[events 0x0801,0x0302]"},
+		{&Msg{Type: MsgReqCodeValidation, Data: []byte{0x01, 0x01, 0x02, 0x03, 0x04}}, "[data redacted]"},
+		{&Msg{Type: msgLogin, Data: []byte{0x01, 0x02, 0x03, 0x04}}, "[data redacted]"},
+		{&Msg{Type: MsgSystemStatus, Data: []byte{0x01, 0x14}}, "[data 0114]"},
+		{&Msg{Type: MsgCommand, Data: []byte{0x01}}, "[data 01]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(traceFields(test.msg)); got != test.want {
+			t.Errorf("traceFields(%s %v) = %s, want %s", test.msg.Type, test.msg.Data, got, test.want)
+		}
+	}
+}
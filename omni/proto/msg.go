@@ -1,5 +1,7 @@
 package proto
 
+//go:generate stringer -type=AppMsgType
+
 import (
 	"bytes"
 	"encoding/binary"
@@ -13,14 +15,35 @@ type AppMsgType uint8
 const (
 	appMsgStart                byte       = 0x21
 	haiPoly                    uint16     = 0xA001
+	MsgAck                     AppMsgType = 0x01
+	MsgNak                     AppMsgType = 0x02
+	MsgEndOfData               AppMsgType = 0x03
+	MsgSetTime                 AppMsgType = 0x13
+	MsgCommand                 AppMsgType = 0x14
+	MsgEnableNotifications     AppMsgType = 0x15
 	MsgReqSystemInfo           AppMsgType = 0x16
+	MsgSystemInfo              AppMsgType = 0x17
 	MsgReqSystemStatus         AppMsgType = 0x18
+	MsgSystemStatus            AppMsgType = 0x19
 	MsgReqSystemTroubles       AppMsgType = 0x1A
+	MsgSystemTroubles          AppMsgType = 0x1B
 	MsgReqSystemFeatures       AppMsgType = 0x1C
+	MsgSystemFeatures          AppMsgType = 0x1D
 	MsgReqSystemFormats        AppMsgType = 0x28
+	MsgSystemFormats           AppMsgType = 0x29
 	MsgReqObjectTypeCapacities AppMsgType = 0x1E
+	MsgObjectTypeCapacities    AppMsgType = 0x1F
 	MsgReqObjectProperties     AppMsgType = 0x20
+	MsgObjectProperties        AppMsgType = 0x21
 	MsgReqObjectStatus         AppMsgType = 0x22
+	MsgObjectStatus            AppMsgType = 0x23
+	MsgReadEventRecord         AppMsgType = 0x24
+	MsgEventLogData            AppMsgType = 0x25
+	MsgReqCodeValidation       AppMsgType = 0x26
+	MsgCodeValidation          AppMsgType = 0x27
+	MsgSystemEvents            AppMsgType = 0x37
+	MsgReqExtendedObjectStatus AppMsgType = 0x3A
+	MsgExtendedObjectStatus    AppMsgType = 0x3B
 )
 
 // Msg is the raw application data message
@@ -35,6 +58,9 @@ func NewMsg(p *packet) (*Msg, error) {
 	buf := bytes.NewBuffer(p.data)
 	var start [1]byte
 	binary.Read(buf, binary.LittleEndian, &start)
+	if start[0] != appMsgStart {
+		return nil, errors.Errorf("Invalid start character %#x on received packet", start[0])
+	}
 
 	var crc, expectedCRC uint16
 	var dataLen uint8
@@ -55,11 +81,35 @@ func NewMsg(p *packet) (*Msg, error) {
 	}
 
 	if crc != expectedCRC {
-		return nil, errors.Errorf("CRC mismatch on received packet")
+		return nil, errors.WithStack(ErrCRC)
 	}
 	return m, nil
 }
 
+// readRawMsg reads the next serialized message from a stream, skipping any bytes before its start character.
+func readRawMsg(r io.Reader) ([]byte, error) {
+	b := []byte{0}
+	for b[0] != appMsgStart {
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var dataLen [1]byte
+	_, err := io.ReadFull(r, dataLen[:])
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 2+int(dataLen[0])+2) // Start, length, type and data, CRC
+	raw[0] = appMsgStart
+	raw[1] = dataLen[0]
+	_, err = io.ReadFull(r, raw[2:])
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
 // Reader returns an io.Reader from the underlying message data.
 func (m *Msg) Reader() io.Reader {
 	return bytes.NewBuffer(m.Data)
@@ -0,0 +1,67 @@
+package proto
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// Dialer connects to a controller address. *net.Dialer is a Dialer, and other implementations
+// can reach controllers through proxies or tunnels.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Option configures a connection.
+type Option func(*options)
+
+type options struct {
+	dialer   Dialer
+	logger   Logger
+	trace    bool
+	recorder *recorder
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		dialer: &net.Dialer{},
+		logger: NopLogger{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithDialer sets the Dialer used to connect to the controller.
+func WithDialer(d Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithLogger sets the Logger which receives connection events. Nothing is logged by default.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		if l != nil {
+			o.logger = l
+		}
+	}
+}
+
+// WithTrace logs every packet sent and received at debug level, with its sequence number, type and
+// a summary of its message. Keys and security codes are never logged.
+func WithTrace() Option {
+	return func(o *options) {
+		o.trace = true
+	}
+}
+
+// WithRecorder writes the decrypted application messages sent and received to w, see Record. Connections
+// made with the same Option share the recording, so it covers every session of a reconnecting client.
+func WithRecorder(w io.Writer) Option {
+	rec := &recorder{w: w}
+	return func(o *options) {
+		o.recorder = rec
+	}
+}
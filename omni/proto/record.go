@@ -0,0 +1,145 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Recordings hold the decrypted application messages of a session, one per line as
+//
+//	<RFC 3339 time> <send|recv> <sequence number> <message type> <message data>
+//
+// with the message type and data in hex and "-" for empty data. Sent messages are the client's
+// requests and received messages are the controller's replies, which have the sequence number of their
+// request, and unsolicited messages, which have sequence number zero. Lines starting with # are comments.
+// The handshake is not recorded, and the data of messages carrying security codes is replaced by zeros,
+// so recordings can be attached to bug reports.
+
+const (
+	recordSend = "send"
+	recordRecv = "recv"
+)
+
+// Record is one message of a recording.
+type Record struct {
+	Time time.Time
+	Sent bool // Sent by the client, otherwise received from the controller
+	Seq  uint16
+	Msg  *Msg
+}
+
+func (r Record) String() string {
+	dir := recordRecv
+	if r.Sent {
+		dir = recordSend
+	}
+	data := "-"
+	if len(r.Msg.Data) > 0 {
+		data = hex.EncodeToString(r.Msg.Data)
+	}
+	return fmt.Sprintf("%s %s %d %02x %s", r.Time.Format(time.RFC3339Nano), dir, r.Seq, uint8(r.Msg.Type), data)
+}
+
+// ReadRecords reads all the records of a recording.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rec, err := parseRecord(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid record on line %d", line)
+		}
+		records = append(records, rec)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to read recording")
+	}
+	return records, nil
+}
+
+func parseRecord(text string) (Record, error) {
+	rec := Record{Msg: &Msg{}}
+	fields := strings.Fields(text)
+	if len(fields) != 5 {
+		return rec, errors.Errorf("Expected 5 fields but found %d", len(fields))
+	}
+	var err error
+	rec.Time, err = time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return rec, err
+	}
+	switch fields[1] {
+	case recordSend:
+		rec.Sent = true
+	case recordRecv:
+	default:
+		return rec, errors.Errorf("Unknown direction %q", fields[1])
+	}
+	seq, err := strconv.ParseUint(fields[2], 10, 16)
+	if err != nil {
+		return rec, err
+	}
+	rec.Seq = uint16(seq)
+	t, err := strconv.ParseUint(fields[3], 16, 8)
+	if err != nil {
+		return rec, err
+	}
+	rec.Msg.Type = AppMsgType(t)
+	if fields[4] != "-" {
+		rec.Msg.Data, err = hex.DecodeString(fields[4])
+		if err != nil {
+			return rec, err
+		}
+	}
+	return rec, nil
+}
+
+// recorder writes the messages of a connection to a recording.
+type recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+// record writes a message, returning the first write error. Nothing is written after an error.
+func (r *recorder) record(sent bool, seq uint16, m *Msg) error {
+	if m.Type == msgLogin || m.Type == MsgReqCodeValidation {
+		m = &Msg{Type: m.Type, Data: make([]byte, len(m.Data))}
+	}
+	rec := Record{Time: time.Now(), Sent: sent, Seq: seq, Msg: m}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return nil
+	}
+	_, err := io.WriteString(r.w, rec.String()+"\n")
+	if err != nil {
+		r.failed = true
+		return errors.Wrap(err, "Failed to write recording")
+	}
+	return nil
+}
+
+// recordMsg adds a message to the connection's recording, if it has one.
+func (c *conn) recordMsg(sent bool, seq uint16, m *Msg) {
+	if c.recorder == nil {
+		return
+	}
+	err := c.recorder.record(sent, seq, m)
+	if err != nil {
+		c.logger.Error("Recording stopped", "addr", c.addr, "err", err)
+	}
+}
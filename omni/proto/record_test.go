@@ -0,0 +1,139 @@
+package proto
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecord(t *testing.T) {
+	rec, err := parseRecord("2026-10-18T09:30:00.5Z recv 12 3b 060e00")
+	if err != nil {
+		t.Fatalf("parseRecord failed: %s", err)
+	}
+	want := time.Date(2026, 10, 18, 9, 30, 0, 5e8, time.UTC)
+	if !rec.Time.Equal(want) || rec.Sent || rec.Seq != 12 || rec.Msg.Type != MsgExtendedObjectStatus ||
+		!bytes.Equal(rec.Msg.Data, []byte{0x06, 0x0e, 0x00}) {
+		t.Errorf("parseRecord returned %+v %+v", rec, rec.Msg)
+	}
+	if s := rec.String(); s != "2026-10-18T09:30:00.5Z recv 12 3b 060e00" {
+		t.Errorf("Record formatted as %q", s)
+	}
+
+	rec, err = parseRecord("2026-10-18T09:30:00Z send 3 16 -")
+	if err != nil {
+		t.Fatalf("parseRecord failed: %s", err)
+	}
+	if !rec.Sent || rec.Seq != 3 || rec.Msg.Type != MsgReqSystemInfo || len(rec.Msg.Data) != 0 {
+		t.Errorf("parseRecord returned %+v %+v", rec, rec.Msg)
+	}
+
+	for _, text := range []string{
+		"2026-10-18T09:30:00Z send 3 16",
+		"2026-10-18T09:30:00Z send 3 16 - extra",
+		"yesterday send 3 16 -",
+		"2026-10-18T09:30:00Z sent 3 16 -",
+		"2026-10-18T09:30:00Z send 70000 16 -",
+		"2026-10-18T09:30:00Z send 3 116 -",
+		"2026-10-18T09:30:00Z send 3 16 0g",
+	} {
+		if _, err := parseRecord(text); err == nil {
+			t.Errorf("parseRecord(%q) succeeded", text)
+		}
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	recording := `# Comment
+
+2026-10-18T09:30:00Z send 3 16 -
+  2026-10-18T09:30:01Z recv 3 17 1e03
+2026-10-18T09:30:02Z recv 0 37 0007
+`
+	records, err := ReadRecords(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %s", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("ReadRecords returned %d records, want 3", len(records))
+	}
+	if !records[0].Sent || records[1].Msg.Type != MsgSystemInfo || records[2].Seq != 0 {
+		t.Errorf("ReadRecords returned %v", records)
+	}
+
+	_, err = ReadRecords(strings.NewReader("# Comment\n2026-10-18T09:30:00Z send 3 16 -\nsend 4 18 -\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("ReadRecords returned %v, want an error on line 3", err)
+	}
+}
+
+// TestRecordRedaction records a serial session, which logs in, validates a code and reads the system
+// information, and replays it. The login and code validation data must not be recorded, and replaying
+// must still answer the code validation.
+func TestRecordRedaction(t *testing.T) {
+	info := &Msg{Type: MsgSystemInfo, Data: []byte{0x1e, 0x03, 0x0e, 0x02}}
+	validation := &Msg{Type: MsgCodeValidation, Data: []byte{0x01, 0x01}}
+
+	port, panelPort := net.Pipe()
+	defer panelPort.Close()
+	fakePanel(panelPort, func(m *Msg) []*Msg {
+		switch m.Type {
+		case MsgReqSystemInfo:
+			return []*Msg{info}
+		case MsgReqCodeValidation:
+			return []*Msg{validation}
+		}
+		return []*Msg{{Type: MsgAck}}
+	})
+
+	ctx, cancel := testSerialContext()
+	defer cancel()
+	recording := &bytes.Buffer{}
+	c, err := NewSerialConnection(ctx, port, "1234", WithRecorder(recording))
+	if err != nil {
+		t.Fatalf("NewSerialConnection failed: %s", err)
+	}
+	code := &Msg{Type: MsgReqCodeValidation, Data: []byte{0x01, 0x01, 0x02, 0x03, 0x04}}
+	for _, m := range []*Msg{code, {Type: MsgReqSystemInfo}} {
+		_, err = c.Request(ctx, m)
+		if err != nil {
+			t.Fatalf("Request %s failed: %s", m.Type, err)
+		}
+	}
+	c.Close()
+
+	records, err := ReadRecords(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %s", err)
+	}
+	var redacted int
+	for _, rec := range records {
+		if rec.Msg.Type == msgLogin || rec.Msg.Type == MsgReqCodeValidation {
+			redacted++
+			if !bytes.Equal(rec.Msg.Data, make([]byte, len(rec.Msg.Data))) {
+				t.Errorf("Recorded %s with data %v", rec.Msg.Type, rec.Msg.Data)
+			}
+		}
+	}
+	if redacted != 2 {
+		t.Errorf("Recorded %d login and code validation messages, want 2:\n%s", redacted, recording)
+	}
+
+	rc, err := NewReplayConn(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReplayConn failed: %s", err)
+	}
+	defer rc.Close()
+	for _, want := range []struct{ req, reply *Msg }{{code, validation}, {&Msg{Type: MsgReqSystemInfo}, info}} {
+		resp, err := rc.Request(context.Background(), want.req)
+		if err != nil {
+			t.Fatalf("Replaying %s failed: %s", want.req.Type, err)
+		}
+		if resp.Type != want.reply.Type || !bytes.Equal(resp.Data, want.reply.Data) {
+			t.Errorf("Replaying %s returned %s %v", want.req.Type, resp.Type, resp.Data)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package proto
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// replayConn is a Conn which answers requests from a recording.
+type replayConn struct {
+	mu      sync.Mutex
+	records []Record
+	used    []bool // Sent records which have been replayed
+	next    int    // Index of the first record not yet released to subscribers
+	err     error
+	done    chan struct{}
+
+	subs map[chan *Msg]struct{}
+}
+
+// NewReplayConn returns a Conn which answers requests with the replies in a recording made by WithRecorder,
+// so sessions with a controller can be reproduced without it. Each request is matched with the first
+// recorded request, not already replayed, of the same type and data, or of the same type if none has the
+// same data, and answered with the recorded reply to it. Unsolicited messages are sent to subscribers once
+// every request recorded before them has been replayed.
+func NewReplayConn(r io.Reader) (Conn, error) {
+	records, err := ReadRecords(r)
+	if err != nil {
+		return nil, err
+	}
+	return &replayConn{
+		records: records,
+		used:    make([]bool, len(records)),
+		done:    make(chan struct{}),
+		subs:    map[chan *Msg]struct{}{},
+	}, nil
+}
+
+func (c *replayConn) Request(ctx context.Context, m *Msg) (*Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ConnError{Op: "write", Addr: "replay", Err: err}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, errors.Wrap(c.err, "Connection not ok")
+	}
+	i := c.match(m)
+	if i < 0 {
+		return nil, errors.Errorf("No recorded request matches %s", m.Type)
+	}
+	c.used[i] = true
+	defer c.release()
+
+	for _, rec := range c.records[i+1:] {
+		if !rec.Sent && rec.Seq == c.records[i].Seq {
+			return &Msg{Type: rec.Msg.Type, Data: append([]byte{}, rec.Msg.Data...)}, nil
+		}
+	}
+	return nil, errors.Errorf("No recorded reply to %s", m.Type)
+}
+
+// match returns the index of the recorded request to answer m with, or -1 if there is none.
+func (c *replayConn) match(m *Msg) int {
+	sameType := -1
+	for i, rec := range c.records {
+		if !rec.Sent || c.used[i] || rec.Msg.Type != m.Type {
+			continue
+		}
+		if bytes.Equal(rec.Msg.Data, m.Data) {
+			return i
+		}
+		if sameType < 0 {
+			sameType = i
+		}
+	}
+	return sameType
+}
+
+// release sends the unsolicited messages up to the first request not yet replayed to the subscribers.
+// The caller must hold c.mu.
+func (c *replayConn) release() {
+	for ; c.next < len(c.records); c.next++ {
+		rec := c.records[c.next]
+		if rec.Sent {
+			if !c.used[c.next] {
+				return
+			}
+			continue
+		}
+		if rec.Seq != 0 {
+			continue
+		}
+		for ch := range c.subs {
+			select {
+			case ch <- &Msg{Type: rec.Msg.Type, Data: append([]byte{}, rec.Msg.Data...)}:
+			default:
+				// Subscriber is not keeping up
+			}
+		}
+	}
+}
+
+func (c *replayConn) Subscribe() (<-chan *Msg, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan *Msg, subBufferSize)
+	if c.err != nil {
+		close(ch)
+	} else {
+		c.subs[ch] = struct{}{}
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if _, ok := c.subs[ch]; ok {
+				delete(c.subs, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, cancel
+}
+
+func (c *replayConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *replayConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Close stops the replay. Close can be called multiple times.
+func (c *replayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil
+	}
+	c.err = ErrClosed
+	for ch := range c.subs {
+		delete(c.subs, ch)
+		close(ch)
+	}
+	close(c.done)
+	return nil
+}
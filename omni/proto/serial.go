@@ -0,0 +1,181 @@
+package proto
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// serialAddr is the address reported for serial connections.
+const serialAddr = "serial"
+
+// The login and logout messages of serial connections are not defined by the Omni-Link II protocol
+// description (document 20P00 rev 3.0, in doc/), which covers network connections only, and these types
+// have not been checked against a controller. They are kept unexported until they are.
+const (
+	msgLogin  AppMsgType = 0x2A
+	msgLogout AppMsgType = 0x2B
+)
+
+// NewSerialConnection creates a session with the controller over its serial port, such as a tty
+// device or one end of an io.Pipe, and logs in with a four digit user code.
+//
+// Serial connections carry unencrypted application data messages without packet headers, so
+// requests are sent one at a time and the next message received of a type which answers the request
+// is taken as its reply. Other messages, such as system events and unsolicited object status, are
+// delivered to subscribers.
+//
+// The login and logout message types are not defined by the protocol description and have not been
+// verified against a controller.
+func NewSerialConnection(ctx context.Context, rwc io.ReadWriteCloser, code string, opts ...Option) (Conn, error) {
+	o := newOptions(opts)
+	login, err := loginData(code)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+		defer cancel()
+	}
+
+	c := &conn{
+		addr:     serialAddr,
+		nconn:    serialPort{rwc},
+		serial:   true,
+		logger:   o.logger,
+		trace:    o.trace,
+		recorder: o.recorder,
+		seqNum:   1,
+		pending:  map[uint16]chan reply{},
+		done:     make(chan struct{}),
+		subs:     map[chan *Msg]struct{}{},
+		turn:     make(chan struct{}, 1),
+	}
+	go c.readLoop()
+
+	resp, err := c.Request(ctx, &Msg{Type: msgLogin, Data: login})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if resp.Type != MsgAck {
+		c.Close()
+		return nil, ConnError{Op: "login", Addr: serialAddr, Err: ErrSessionRejected}
+	}
+	c.logger.Info("Session established", "addr", serialAddr)
+	return c, nil
+}
+
+// loginData converts a four digit user code into the login message data, one digit per byte.
+func loginData(code string) ([]byte, error) {
+	if len(code) != 4 {
+		return nil, errors.Errorf("Code must be four digits")
+	}
+	data := make([]byte, len(code))
+	for i, d := range code {
+		if d < '0' || d > '9' {
+			return nil, errors.Errorf("Code must be four digits")
+		}
+		data[i] = byte(d - '0')
+	}
+	return data, nil
+}
+
+// recvSerial reads the next message from a serial connection as an application data packet. Messages
+// which answer a request are given a non-zero sequence number, and unsolicited messages zero.
+func (c *conn) recvSerial() (*packet, error) {
+	raw, err := readRawMsg(c.nconn)
+	if err != nil {
+		return nil, ConnError{Op: "read", Addr: c.addr, Err: err}
+	}
+	p := &packet{
+		msgType: msgAppData,
+		data:    raw,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.awaiting != 0 && answers(c.awaitingType, AppMsgType(raw[2])) {
+		p.seqNum = c.awaiting
+		c.awaiting = 0
+	}
+	return p, nil
+}
+
+// serialReplies lists the message types which answer each request, besides a negative acknowledgement.
+// Requests which are not listed are answered by an acknowledgement.
+var serialReplies = map[AppMsgType][]AppMsgType{
+	MsgReqSystemInfo:           {MsgSystemInfo},
+	MsgReqSystemStatus:         {MsgSystemStatus},
+	MsgReqSystemTroubles:       {MsgSystemTroubles},
+	MsgReqSystemFeatures:       {MsgSystemFeatures},
+	MsgReqSystemFormats:        {MsgSystemFormats},
+	MsgReqObjectTypeCapacities: {MsgObjectTypeCapacities},
+	MsgReqObjectProperties:     {MsgObjectProperties, MsgEndOfData},
+	MsgReqObjectStatus:         {MsgObjectStatus},
+	MsgReadEventRecord:         {MsgEventLogData, MsgEndOfData},
+	MsgReqCodeValidation:       {MsgCodeValidation},
+	MsgReqExtendedObjectStatus: {MsgExtendedObjectStatus},
+}
+
+// answers reports whether a message of type reply answers a request of type req.
+func answers(req, reply AppMsgType) bool {
+	if reply == MsgNak {
+		return true
+	}
+	replies, ok := serialReplies[req]
+	if !ok {
+		return reply == MsgAck
+	}
+	for _, t := range replies {
+		if t == reply {
+			return true
+		}
+	}
+	return false
+}
+
+// serialPort adapts a serial port to the net.Conn used by conn. Deadlines are passed on to ports
+// which support them, such as *os.File, and are otherwise ignored.
+type serialPort struct {
+	io.ReadWriteCloser
+}
+
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func (s serialPort) LocalAddr() net.Addr  { return serialPortAddr{} }
+func (s serialPort) RemoteAddr() net.Addr { return serialPortAddr{} }
+
+func (s serialPort) SetDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(deadliner); ok {
+		return d.SetDeadline(t)
+	}
+	return nil
+}
+
+func (s serialPort) SetReadDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (s serialPort) SetWriteDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+type serialPortAddr struct{}
+
+func (serialPortAddr) Network() string { return serialAddr }
+func (serialPortAddr) String() string  { return serialAddr }
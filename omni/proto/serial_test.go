@@ -0,0 +1,126 @@
+package proto
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakePanel is the controller end of a serial connection. It answers each message received with the
+// messages returned by handle and sends every message received on msgs.
+func fakePanel(port net.Conn, handle func(m *Msg) []*Msg) <-chan *Msg {
+	msgs := make(chan *Msg, 10)
+	go func() {
+		defer close(msgs)
+		for {
+			raw, err := readRawMsg(port)
+			if err != nil {
+				return
+			}
+			m, err := NewMsg(&packet{msgType: msgAppData, data: raw})
+			if err != nil {
+				return
+			}
+			msgs <- m
+			for _, reply := range handle(m) {
+				_, err = port.Write(reply.serialize())
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return msgs
+}
+
+func testSerialContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func TestSerialLogin(t *testing.T) {
+	port, panelPort := net.Pipe()
+	defer panelPort.Close()
+	msgs := fakePanel(panelPort, func(m *Msg) []*Msg {
+		return []*Msg{{Type: MsgAck}}
+	})
+
+	ctx, cancel := testSerialContext()
+	defer cancel()
+	c, err := NewSerialConnection(ctx, port, "1234")
+	if err != nil {
+		t.Fatalf("NewSerialConnection failed: %s", err)
+	}
+	login := <-msgs
+	if login.Type != msgLogin || !bytes.Equal(login.Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("Panel received login %s %v", login.Type, login.Data)
+	}
+
+	c.Close()
+	if logout := <-msgs; logout == nil || logout.Type != msgLogout {
+		t.Errorf("Panel received %v on close, want logout", logout)
+	}
+}
+
+func TestSerialLoginRejected(t *testing.T) {
+	port, panelPort := net.Pipe()
+	defer panelPort.Close()
+	fakePanel(panelPort, func(m *Msg) []*Msg {
+		return []*Msg{{Type: MsgNak}}
+	})
+
+	ctx, cancel := testSerialContext()
+	defer cancel()
+	_, err := NewSerialConnection(ctx, port, "9999")
+	if !errors.Is(err, ErrSessionRejected) {
+		t.Fatalf("NewSerialConnection returned %v, want %s", err, ErrSessionRejected)
+	}
+}
+
+// TestSerialUnsolicited sends an object status and a system event ahead of the reply to a system
+// status request. Neither may be taken as the reply, and both must reach subscribers.
+func TestSerialUnsolicited(t *testing.T) {
+	status := &Msg{Type: MsgObjectStatus, Data: []byte{0x02, 0x00, 0x01, 0x01, 0x00, 0x00}}
+	event := &Msg{Type: MsgSystemEvents, Data: []byte{0x08, 0x01}}
+	reply := &Msg{Type: MsgSystemStatus, Data: []byte{0x01, 0x14, 0x0A, 0x12}}
+
+	port, panelPort := net.Pipe()
+	defer panelPort.Close()
+	fakePanel(panelPort, func(m *Msg) []*Msg {
+		if m.Type == MsgReqSystemStatus {
+			return []*Msg{status, event, reply}
+		}
+		return []*Msg{{Type: MsgAck}}
+	})
+
+	ctx, cancel := testSerialContext()
+	defer cancel()
+	c, err := NewSerialConnection(ctx, port, "1234")
+	if err != nil {
+		t.Fatalf("NewSerialConnection failed: %s", err)
+	}
+	defer c.Close()
+	sub, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+
+	resp, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus})
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if resp.Type != reply.Type || !bytes.Equal(resp.Data, reply.Data) {
+		t.Errorf("Request received %s %v, want %s %v", resp.Type, resp.Data, reply.Type, reply.Data)
+	}
+	for _, want := range []*Msg{status, event} {
+		select {
+		case m := <-sub:
+			if m.Type != want.Type || !bytes.Equal(m.Data, want.Data) {
+				t.Errorf("Subscriber received %s %v, want %s %v", m.Type, m.Data, want.Type, want.Data)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Subscriber did not receive %s", want.Type)
+		}
+	}
+}
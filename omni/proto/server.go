@@ -0,0 +1,140 @@
+package proto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// controllerProtoVersion is the protocol version reported by ServerConn during the handshake.
+	controllerProtoVersion = 1
+	// serverWriteTimeout bounds each write to the client, so one which stops reading cannot hold up the
+	// controller indefinitely.
+	serverWriteTimeout = 10 * time.Second
+)
+
+// ServerConn is the controller side of an Omni-Link II session. It allows programs such as
+// simulators to answer requests from clients. Reply and Notify may be called from multiple
+// goroutines, but only one goroutine should call ReadRequest.
+type ServerConn struct {
+	c *conn
+}
+
+// Accept performs the controller side of the session handshake over nconn using the given key.
+// The handshake must complete before the deadline, a zero deadline means no deadline.
+func Accept(nconn net.Conn, key StaticKey, deadline time.Time) (*ServerConn, error) {
+	c := &conn{
+		addr:   nconn.RemoteAddr().String(),
+		nconn:  nconn,
+		logger: NopLogger{},
+	}
+
+	// New Session
+	reqp, err := c.recvPacket(deadline)
+	if err != nil {
+		return nil, err
+	}
+	if reqp.msgType != msgClientReqNewSession {
+		return nil, fmt.Errorf("Expected new session request but received packet type %d", reqp.msgType)
+	}
+	as := ackNewSession{ProtoVersion: controllerProtoVersion}
+	_, err = rand.Read(as.SessionID[:])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create session id - %s", err)
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, as)
+	err = c.sendPacket(&packet{seqNum: reqp.seqNum, msgType: msgControllerAckNewSession, data: buf.Bytes()}, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	c.protoVersion = as.ProtoVersion
+	c.sessionKey, err = createSessionKey(key, as.SessionID[:])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create session key - %s", err)
+	}
+	c.cipher, err = aes.NewCipher(c.sessionKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create controller cipher - %s", err.Error())
+	}
+
+	// Secure connection
+	secp, err := c.recvPacket(deadline)
+	if err != nil {
+		return nil, err
+	}
+	if secp.msgType != msgClientReqSecureConnection {
+		return nil, fmt.Errorf("Expected secure connection request but received packet type %d", secp.msgType)
+	}
+	if !bytes.Equal(secp.data[:len(as.SessionID)], as.SessionID[:]) {
+		// The client is using a different key, which the controller reports by ending the session
+		c.sendPacket(&packet{seqNum: secp.seqNum, msgType: msgControllerSessionTerminated}, deadline)
+		return nil, errors.Wrapf(ErrBadKey, "Client %s sent the wrong session id", c.addr)
+	}
+	err = c.sendPacket(&packet{seqNum: secp.seqNum, msgType: msgControllerAckSecureConnection, data: as.SessionID[:]}, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clear the handshake deadlines
+	nconn.SetDeadline(time.Time{})
+	return &ServerConn{c: c}, nil
+}
+
+// ReadRequest returns the next application message sent by the client along with the sequence
+// number to reply with. io.EOF is returned once the client terminates the session.
+func (s *ServerConn) ReadRequest() (uint16, *Msg, error) {
+	for {
+		p, err := s.c.recvPacket(time.Time{})
+		if err != nil {
+			return 0, nil, err
+		}
+		switch p.msgType {
+		case msgClientSessionTerminated:
+			return 0, nil, io.EOF
+		case msgAppData:
+			m, err := NewMsg(p)
+			return p.seqNum, m, err
+		}
+	}
+}
+
+// Reply sends the response to the request with the given sequence number.
+func (s *ServerConn) Reply(seqNum uint16, m *Msg) error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+
+	return s.c.sendPacket(m.packet(seqNum), time.Now().Add(serverWriteTimeout))
+}
+
+// Notify sends an unsolicited message, such as an event notification, to the client.
+func (s *ServerConn) Notify(m *Msg) error {
+	return s.Reply(0, m)
+}
+
+// Terminate ends the session the way a controller does when it is restarting and closes the connection.
+func (s *ServerConn) Terminate() error {
+	s.c.mu.Lock()
+	err := s.c.sendPacket(&packet{msgType: msgControllerSessionTerminated}, time.Now().Add(serverWriteTimeout))
+	s.c.mu.Unlock()
+
+	cerr := s.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// Close closes the connection without notifying the client.
+func (s *ServerConn) Close() error {
+	return s.c.Close()
+}
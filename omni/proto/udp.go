@@ -0,0 +1,64 @@
+package proto
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// retransmitInterval is how long a datagram connection waits for a reply before resending a request.
+	retransmitInterval = time.Second
+	// maxRetransmits is the number of times a request is resent before giving up on a reply.
+	maxRetransmits = 3
+	// maxDatagramSize is larger than the biggest packet, an encrypted 255 byte application message.
+	maxDatagramSize = 512
+)
+
+// NewUDPConnection creates a new session with the controller over UDP. Requests which are not answered
+// are resent, and duplicate replies are discarded. The context bounds the session handshake; if it has
+// no deadline, handshakeTimeout is used.
+func NewUDPConnection(ctx context.Context, addr string, key StaticKey, opts ...Option) (Conn, error) {
+	return dial(ctx, "udp", addr, key, opts)
+}
+
+// exchange sends a handshake packet and returns the controller's response. Datagram connections
+// resend the packet if the response does not arrive in time and ignore responses to other packets.
+func (c *conn) exchange(p *packet, timeout time.Time) (*packet, error) {
+	for attempt := 0; ; attempt++ {
+		err := c.sendPacket(p, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		wait := timeout
+		retry := c.datagram && attempt < maxRetransmits
+		if retry {
+			wait = time.Now().Add(c.retransmit)
+			if !timeout.IsZero() && timeout.Before(wait) {
+				wait = timeout
+				retry = false
+			}
+		}
+		for {
+			resp, err := c.recvPacket(wait)
+			if err != nil {
+				if retry && isTimeout(err) {
+					break
+				}
+				return nil, err
+			}
+			if !c.datagram || resp.seqNum == p.seqNum {
+				return resp, nil
+			}
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	if ce, ok := err.(ConnError); ok {
+		err = ce.Err
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
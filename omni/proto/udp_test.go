@@ -0,0 +1,179 @@
+package proto
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lossyConn is the client end of a UDP session which discards the datagrams it is told to drop
+// instead of sending them.
+type lossyConn struct {
+	*net.UDPConn
+
+	mu    sync.Mutex
+	sent  int
+	drops map[int]bool
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	drop := c.drops[c.sent]
+	c.sent++
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.UDPConn.Write(b)
+}
+
+// duplicatingConn is the controller end of a UDP session. It reads datagrams as the byte stream
+// ServerConn expects and sends every datagram to the client twice.
+type duplicatingConn struct {
+	*net.UDPConn
+	client net.Addr
+	buf    []byte
+}
+
+func (c *duplicatingConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		buf := make([]byte, maxDatagramSize)
+		n, _, err := c.UDPConn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		c.buf = buf[:n]
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *duplicatingConn) Write(b []byte) (int, error) {
+	for i := 0; i < 2; i++ {
+		_, err := c.UDPConn.WriteTo(b, c.client)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *duplicatingConn) RemoteAddr() net.Addr {
+	return c.client
+}
+
+// TestUDPRetransmission drops the client's first handshake packet and first request, and duplicates
+// every controller packet. The dropped packets must be resent and each request must receive its own
+// reply rather than the duplicate of an earlier one.
+func TestUDPRetransmission(t *testing.T) {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %s", err)
+	}
+	defer ln.Close()
+	uconn, err := net.DialUDP("udp", nil, ln.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP failed: %s", err)
+	}
+	// Writes 0 and 1 are the new session request and its resend, 2 is the secure connection request and
+	// 3 is the first application request
+	cconn := &lossyConn{UDPConn: uconn, drops: map[int]bool{0: true, 3: true}}
+
+	requests := make(chan []byte, 10)
+	go func() {
+		sconn := &duplicatingConn{UDPConn: ln, client: uconn.LocalAddr()}
+		s, err := Accept(sconn, testKey, time.Now().Add(10*time.Second))
+		if err != nil {
+			t.Errorf("Accept failed: %s", err)
+			return
+		}
+		for {
+			seqNum, req, err := s.ReadRequest()
+			if err != nil {
+				return
+			}
+			requests <- req.Data
+			s.Reply(seqNum, &Msg{Type: MsgSystemStatus, Data: req.Data})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := NewConnectionFromNetConn(ctx, cconn, testKey)
+	if err != nil {
+		t.Fatalf("NewConnectionFromNetConn failed: %s", err)
+	}
+	defer c.Close()
+
+	for i := byte(1); i <= 3; i++ {
+		resp, err := c.Request(ctx, &Msg{Type: MsgReqSystemStatus, Data: []byte{i}})
+		if err != nil {
+			t.Fatalf("Request %d failed: %s", i, err)
+		}
+		if !bytes.Equal(resp.Data, []byte{i}) {
+			t.Fatalf("Request %d received reply %v", i, resp.Data)
+		}
+	}
+	for i := byte(1); i <= 3; i++ {
+		if data := <-requests; !bytes.Equal(data, []byte{i}) {
+			t.Errorf("Controller received request %v, want %v", data, []byte{i})
+		}
+	}
+	if len(requests) != 0 {
+		t.Errorf("Controller received %d extra requests", len(requests))
+	}
+	cconn.mu.Lock()
+	defer cconn.mu.Unlock()
+	if cconn.sent != 7 {
+		t.Errorf("Client sent %d packets, want 7", cconn.sent)
+	}
+}
+
+// TestUDPNoReply checks that a request which the controller never answers fails once the last
+// retransmit goes unanswered, even when its context has no deadline.
+func TestUDPNoReply(t *testing.T) {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %s", err)
+	}
+	defer ln.Close()
+	uconn, err := net.DialUDP("udp", nil, ln.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP failed: %s", err)
+	}
+	cconn := &lossyConn{UDPConn: uconn}
+
+	go func() {
+		sconn := &duplicatingConn{UDPConn: ln, client: uconn.LocalAddr()}
+		_, err := Accept(sconn, testKey, time.Now().Add(10*time.Second))
+		if err != nil {
+			t.Errorf("Accept failed: %s", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := NewConnectionFromNetConn(ctx, cconn, testKey)
+	if err != nil {
+		t.Fatalf("NewConnectionFromNetConn failed: %s", err)
+	}
+	defer c.Close()
+	c.(*conn).retransmit = 20 * time.Millisecond
+
+	_, err = c.Request(context.Background(), &Msg{Type: MsgReqSystemStatus})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Unanswered request returned %v, want ErrTimeout", err)
+	}
+	cconn.mu.Lock()
+	defer cconn.mu.Unlock()
+	// Two handshake packets, the request and its retransmits
+	if want := 3 + maxRetransmits; cconn.sent != want {
+		t.Errorf("Client sent %d packets, want %d", cconn.sent, want)
+	}
+}
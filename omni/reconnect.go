@@ -0,0 +1,127 @@
+package omni
+
+import (
+	"context"
+	"time"
+
+	"github.com/leelynne/omnilink/omni/proto"
+	"github.com/pkg/errors"
+)
+
+//go:generate stringer -type=ConnState
+
+// ConnState is the state of a Client's session with the controller.
+type ConnState uint8
+
+const (
+	Connecting ConnState = iota // Dialing and establishing a secure session
+	Secure                      // Session established, requests can be sent
+	Lost                        // Connection failed or the session was terminated
+)
+
+// setState reports a connection state change to the state function.
+func (c *Client) setState(state ConnState, err error) {
+	c.logger.Debug("Connection state changed", "addr", c.Addr, "state", state, "err", err)
+	if c.stateFunc != nil {
+		c.stateFunc(state, err)
+	}
+}
+
+// maintain establishes a new session each time the connection fails, until the Client is closed.
+func (c *Client) maintain(conn proto.Conn) {
+	for {
+		select {
+		case <-conn.Done():
+		case <-c.stop:
+			return
+		}
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+		c.setState(Lost, conn.Err())
+		conn.Close()
+
+		conn = c.redial()
+		if conn == nil {
+			return
+		}
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return
+		}
+		c.conn = conn
+		close(c.ready)
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+		c.logger.Info("Reconnected", "addr", c.Addr)
+		c.setState(Secure, nil)
+	}
+}
+
+// redial connects to the controller, backing off between failed attempts. It returns nil if the Client is closed first.
+func (c *Client) redial() proto.Conn {
+	backoff := c.minBackoff
+	for {
+		c.setState(Connecting, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		conn, err := c.connect(ctx, c.Addr, c.key, c.connOpts...)
+		cancel()
+		if err == nil {
+			return conn
+		}
+		c.setState(Lost, err)
+		c.logger.Warn("Failed to reconnect", "addr", c.Addr, "err", err, "retry", backoff)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-c.stop:
+			t.Stop()
+			return nil
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// connection returns the connection to send requests on. While a reconnecting Client is establishing
+// a new session it waits for the session or for ctx to be done.
+func (c *Client) connection(ctx context.Context) (proto.Conn, error) {
+	for {
+		c.mu.Lock()
+		conn, ready, closed := c.conn, c.ready, c.closed
+		c.mu.Unlock()
+		if closed {
+			return nil, errors.New("Client is closed")
+		}
+		if !c.reconnect {
+			return conn, nil
+		}
+
+		select {
+		case <-conn.Done():
+		default:
+			return conn, nil
+		}
+		select {
+		case <-ready:
+		case <-c.stop:
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "Waiting to reconnect")
+		}
+	}
+}
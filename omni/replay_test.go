@@ -0,0 +1,113 @@
+package omni_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/leelynne/omnilink/omni"
+	"github.com/leelynne/omnilink/omni/proto"
+)
+
+// TestReplay replays testdata/session.rec, recorded from a client of omnisim.Demo, and checks the
+// decoded replies.
+func TestReplay(t *testing.T) {
+	f, err := os.Open("testdata/session.rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	conn, err := proto.NewReplayConn(f)
+	if err != nil {
+		t.Fatalf("NewReplayConn failed: %s", err)
+	}
+	c, err := omni.NewClientFromConn(conn)
+	if err != nil {
+		t.Fatalf("NewClientFromConn failed: %s", err)
+	}
+	defer c.Close()
+	ctx, cancel := testContext()
+	defer cancel()
+
+	info, err := c.GetSystemInformation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModelNumber != 30 || info.MajorVersion != 3 || info.MinorVersion != 14 {
+		t.Errorf("Unexpected system information %+v", info)
+	}
+	st, err := c.GetSystemStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Year != 26 || st.Month != 10 || st.Day != 18 || st.Battery != 200 {
+		t.Errorf("Unexpected system status %+v", st)
+	}
+
+	zones, err := c.Zones(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 3 || name(zones[0].Name[:]) != "FRONT DOOR" || name(zones[2].Name[:]) != "GARAGE" {
+		t.Errorf("Unexpected zones %+v", zones)
+	}
+
+	units, err := c.UnitStatus(ctx, omni.Range{Start: 1, End: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 120 {
+		t.Fatalf("Received %d unit records, want 120", len(units))
+	}
+	for i, u := range units {
+		n := int(u.NumberMSB)<<8 | int(u.NumberLSB)
+		if n != i+1 || int(u.TimeMSB)<<8|int(u.TimeLSB) != n {
+			t.Fatalf("Unit record %d is %+v", i, u)
+		}
+	}
+
+	thermostats, err := c.ExtendedThermostatStatus(ctx, omni.Range{Start: 1, End: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thermostats) != 1 || thermostats[0].Humidity != 45 || thermostats[0].OutdoorTemperature.Celsius() != 5 {
+		t.Errorf("Unexpected extended thermostat status %+v", thermostats)
+	}
+
+	// The code is redacted in the recording, so any code matches the recorded request
+	v, err := c.ValidateCode(ctx, 1, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.UserCode != 1 || v.Authority != omni.Master {
+		t.Errorf("Unexpected code validation %+v", v)
+	}
+
+	events, err := c.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() omni.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for an event")
+		}
+		return nil
+	}
+	if e, ok := next().(omni.UserMacroButtonEvent); !ok || e.Button != 7 {
+		t.Errorf("Received %#v, want button 7", e)
+	}
+	err = c.UnitOn(ctx, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := next().(omni.UnitStatusEvent); !ok || e.NumberLSB != 3 || e.State != 1 {
+		t.Errorf("Received %#v, want unit 3 on", e)
+	}
+
+	_, err = c.GetSystemTroubles(ctx)
+	if err == nil {
+		t.Error("Replaying a request which was not recorded succeeded")
+	}
+}
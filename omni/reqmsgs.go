@@ -5,6 +5,7 @@ package omni
 //go:generate stringer -type=TempFormat
 //go:generate stringer -type=TimeFormat
 //go:generate stringer -type=DateFormat
+//go:generate stringer -type=AuthorityLevel
 
 type SystemTrouble uint8
 
@@ -54,6 +55,16 @@ const (
 	DDMM
 )
 
+// AuthorityLevel is the authority of a user code reported by security code validation.
+type AuthorityLevel uint8
+
+const (
+	InvalidCode AuthorityLevel = iota
+	Master
+	Manager
+	User
+)
+
 type SystemInfo struct {
 	ModelNumber      uint8
 	MajorVersion     uint8
@@ -79,6 +90,12 @@ type SystemStatus struct {
 	Battery     uint8
 }
 
+// CodeValidation is the result of validating a four digit security code in an area.
+type CodeValidation struct {
+	UserCode  uint8 // User code number, CodeDuress for the duress code or zero if the code is invalid
+	Authority AuthorityLevel
+}
+
 type SystemTroubles struct {
 	Troubles []SystemTrouble
 }
@@ -113,8 +130,3 @@ type ZoneReadyStatus struct {
 
 type ConnectedSecuritySystemStatus struct {
 }
-
-func omniTempToF(otemp uint8) float64 {
-	celsius := -40.0 + (float64(otemp) / 2.0)
-	return celsius*1.8 + 32
-}
@@ -0,0 +1,99 @@
+package omni
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Temperature is a temperature in the Omni temperature format, where each step is 0.5 degC from
+// 0 at -40 degC (-40 degF) to 255 at 87.5 degC (189.5 degF).
+type Temperature uint8
+
+// Humidity is a relative humidity in the Omni temperature format, where 0-100 degF correspond to
+// 0-100% relative humidity.
+type Humidity uint8
+
+// Celsius returns the temperature in degrees Celsius.
+func (t Temperature) Celsius() float64 {
+	return float64(t)/2 - 40
+}
+
+// Fahrenheit returns the temperature in degrees Fahrenheit.
+func (t Temperature) Fahrenheit() float64 {
+	return t.Celsius()*1.8 + 32
+}
+
+// In returns the temperature in the given format, such as the controller's SystemFormats.TempFormat.
+func (t Temperature) In(f TempFormat) float64 {
+	if f == Celsius {
+		return t.Celsius()
+	}
+	return t.Fahrenheit()
+}
+
+// Format returns the temperature with its unit in the given format, such as "72.5°F".
+func (t Temperature) Format(f TempFormat) string {
+	unit := "F"
+	if f == Celsius {
+		unit = "C"
+	}
+	return fmt.Sprintf("%.1f°%s", t.In(f), unit)
+}
+
+// String returns the temperature in degrees Fahrenheit.
+func (t Temperature) String() string {
+	return t.Format(Fahrenheit)
+}
+
+// NewTemperature converts a temperature in the given format to the nearest Omni temperature.
+func NewTemperature(v float64, f TempFormat) (Temperature, error) {
+	if f == Celsius {
+		return TemperatureFromCelsius(v)
+	}
+	return TemperatureFromFahrenheit(v)
+}
+
+// TemperatureFromCelsius converts degrees Celsius to the nearest Omni temperature.
+func TemperatureFromCelsius(c float64) (Temperature, error) {
+	raw := math.Round((c + 40) * 2)
+	if math.IsNaN(raw) || raw < 0 || raw > math.MaxUint8 {
+		return 0, errors.Errorf("Temperature %.1f°C is outside -40.0°C to 87.5°C", c)
+	}
+	return Temperature(raw), nil
+}
+
+// TemperatureFromFahrenheit converts degrees Fahrenheit to the nearest Omni temperature.
+func TemperatureFromFahrenheit(f float64) (Temperature, error) {
+	t, err := TemperatureFromCelsius((f - 32) / 1.8)
+	if err != nil {
+		return 0, errors.Errorf("Temperature %.1f°F is outside -40.0°F to 189.5°F", f)
+	}
+	return t, nil
+}
+
+// Percent returns the relative humidity in percent.
+func (h Humidity) Percent() int {
+	p := int(math.Round(Temperature(h).Fahrenheit()))
+	if p < 0 {
+		return 0
+	}
+	if p > 100 {
+		return 100
+	}
+	return p
+}
+
+func (h Humidity) String() string {
+	return fmt.Sprintf("%d%%", h.Percent())
+}
+
+// HumidityFromPercent converts a relative humidity in percent to the Omni temperature format.
+func HumidityFromPercent(p int) (Humidity, error) {
+	if p < 0 || p > 100 {
+		return 0, errors.Errorf("Humidity %d%% is outside 0-100%%", p)
+	}
+	t, err := TemperatureFromFahrenheit(float64(p))
+	return Humidity(t), err
+}
@@ -0,0 +1,99 @@
+package omni_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/leelynne/omnilink/omni"
+)
+
+func TestTemperatureConversions(t *testing.T) {
+	tests := []struct {
+		raw        omni.Temperature
+		celsius    float64
+		fahrenheit float64
+	}{
+		{0, -40, -40},
+		{80, 0, 32},
+		{124, 22, 71.6},
+		{180, 50, 122},
+		{255, 87.5, 189.5},
+	}
+	for _, test := range tests {
+		if c := test.raw.Celsius(); c != test.celsius {
+			t.Errorf("Temperature %d is %v°C, want %v°C", test.raw, c, test.celsius)
+		}
+		if f := test.raw.Fahrenheit(); math.Abs(f-test.fahrenheit) > 1e-9 {
+			t.Errorf("Temperature %d is %v°F, want %v°F", test.raw, f, test.fahrenheit)
+		}
+		if got, err := omni.TemperatureFromCelsius(test.celsius); err != nil || got != test.raw {
+			t.Errorf("TemperatureFromCelsius(%v) = %d, %v, want %d", test.celsius, got, err, test.raw)
+		}
+		if got, err := omni.TemperatureFromFahrenheit(test.fahrenheit); err != nil || got != test.raw {
+			t.Errorf("TemperatureFromFahrenheit(%v) = %d, %v, want %d", test.fahrenheit, got, err, test.raw)
+		}
+	}
+
+	// Every Omni temperature survives a round trip through both scales
+	for raw := 0; raw <= math.MaxUint8; raw++ {
+		temp := omni.Temperature(raw)
+		for _, f := range []omni.TempFormat{omni.Celsius, omni.Fahrenheit} {
+			got, err := omni.NewTemperature(temp.In(f), f)
+			if err != nil || got != temp {
+				t.Errorf("Temperature %d converted to %s and back is %d, %v", raw, temp.Format(f), got, err)
+			}
+		}
+	}
+
+	// Values are rounded to the nearest half degree Celsius
+	if got, _ := omni.TemperatureFromCelsius(21.74); got.Celsius() != 21.5 {
+		t.Errorf("21.74°C converted to %v°C, want 21.5°C", got.Celsius())
+	}
+	for _, c := range []float64{-40.3, 87.8, math.NaN(), math.Inf(1)} {
+		if _, err := omni.TemperatureFromCelsius(c); err == nil {
+			t.Errorf("TemperatureFromCelsius(%v) succeeded", c)
+		}
+	}
+	for _, f := range []float64{-40.6, 190} {
+		if _, err := omni.TemperatureFromFahrenheit(f); err == nil {
+			t.Errorf("TemperatureFromFahrenheit(%v) succeeded", f)
+		}
+	}
+}
+
+func TestHumidityConversions(t *testing.T) {
+	tests := []struct {
+		raw     omni.Humidity
+		percent int
+	}{
+		{44, 0},
+		{100, 50},
+		{156, 100},
+	}
+	for _, test := range tests {
+		if p := test.raw.Percent(); p != test.percent {
+			t.Errorf("Humidity %d is %d%%, want %d%%", test.raw, p, test.percent)
+		}
+		if got, err := omni.HumidityFromPercent(test.percent); err != nil || got != test.raw {
+			t.Errorf("HumidityFromPercent(%d) = %d, %v, want %d", test.percent, got, err, test.raw)
+		}
+	}
+	for p := 0; p <= 100; p++ {
+		h, err := omni.HumidityFromPercent(p)
+		if err != nil || h.Percent() != p {
+			t.Errorf("Humidity %d%% converted to %d and back is %d%%, %v", p, h, h.Percent(), err)
+		}
+	}
+	// Values outside 0-100% are clamped when reading and refused when converting
+	if p := omni.Humidity(0).Percent(); p != 0 {
+		t.Errorf("Humidity 0 is %d%%, want 0%%", p)
+	}
+	if p := omni.Humidity(255).Percent(); p != 100 {
+		t.Errorf("Humidity 255 is %d%%, want 100%%", p)
+	}
+	for _, p := range []int{-1, 101} {
+		if _, err := omni.HumidityFromPercent(p); err == nil {
+			t.Errorf("HumidityFromPercent(%d) succeeded", p)
+		}
+	}
+}